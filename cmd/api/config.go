@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anukuljoshi/greenlight/internal/data"
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+	"github.com/anukuljoshi/greenlight/internal/validator"
+)
+
+// config struct to hold settings for our application
+type config struct {
+	port            int
+	env             string
+	storage         string
+	maintenanceMode bool
+	log             struct {
+		level              jsonlog.Level
+		stdout             bool
+		file               string
+		fileMaxSize        int
+		fileRotateInterval time.Duration
+		fileMaxBackups     int
+		fileMaxAge         time.Duration
+		syslog             string
+		syslogFacility     string
+	}
+	db struct {
+		dsn          string
+		maxOpenConns int
+		maxIdleConns int
+		maxIdleTime  string
+		queryTimeout time.Duration
+	}
+	limiter struct {
+		rps     float64
+		burst   int
+		enabled bool
+	}
+	mailer struct {
+		transport string
+		dir       string
+	}
+	outbox struct {
+		interval    time.Duration
+		maxAttempts int
+		backoff     time.Duration
+	}
+	maintenance struct {
+		tokensInterval  time.Duration
+		usersInterval   time.Duration
+		unactivatedDays int
+	}
+	jobs struct {
+		concurrency  int
+		pollInterval time.Duration
+		maxAttempts  int
+		backoff      time.Duration
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	cors struct {
+		trustedOrigins []string
+	}
+	users struct {
+		defaultRole string
+	}
+	cache struct {
+		ttl time.Duration
+	}
+	debug struct {
+		addr string
+	}
+	tracing struct {
+		exporter     string
+		otlpEndpoint string
+		sampleRatio  float64
+	}
+}
+
+// settings are read from these sources, from the lowest to the highest precedence
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// prefix of the environment variable of a setting, e.g. GREENLIGHT_DB_DSN
+// for -db-dsn
+const envPrefix = "GREENLIGHT_"
+
+// errUsage is returned for invalid command line args, which the flag set
+// reports together with the usage
+var errUsage = errors.New("invalid command line arguments")
+
+// settings which are never printed
+var secretSettings = map[string]bool{
+	"db-dsn":        true,
+	"smtp-password": true,
+}
+
+// settings holds the config and the flags it was read from
+type settings struct {
+	config  config
+	flags   *flag.FlagSet
+	sources map[string]string
+
+	// flags which only affect how the program is started, they can't be set
+	// in the environment or the config file
+	configFile  string
+	printConfig bool
+	version     bool
+}
+
+// fieldsValue is a flag.Value for a list of space separated strings
+type fieldsValue []string
+
+func (v *fieldsValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return strings.Join(*v, " ")
+}
+
+func (v *fieldsValue) Set(s string) error {
+	*v = strings.Fields(s)
+	return nil
+}
+
+// defines a flag for every setting of cfg with its default value
+func newFlagSet(cfg *config) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	fs.IntVar(&cfg.port, "port", 4000, "PORT for application")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	fs.StringVar(&cfg.storage, "storage", "postgres", "Storage backend (postgres|memory)")
+	fs.BoolVar(&cfg.maintenanceMode, "maintenance-mode", false, "Answer API requests with 503 Service Unavailable, e.g. during database migrations")
+
+	// read logging settings from flags, entries are written to every
+	// configured output
+	fs.TextVar(&cfg.log.level, "log-level", jsonlog.LevelInfo, "Minimum level of log entries (debug|info|warn|error|fatal|off)")
+	fs.BoolVar(&cfg.log.stdout, "log-stdout", true, "Write logs to stdout")
+	fs.StringVar(&cfg.log.file, "log-file", "", "Write logs to this file, rotated by size and time (empty disables)")
+	fs.IntVar(&cfg.log.fileMaxSize, "log-file-max-size", 100, "Size in MB after which the log file is rotated (0 disables)")
+	fs.DurationVar(&cfg.log.fileRotateInterval, "log-file-rotate-interval", 24*time.Hour, "Rotate the log file at every multiple of this interval since midnight UTC (0 disables)")
+	fs.IntVar(&cfg.log.fileMaxBackups, "log-file-max-backups", 7, "Number of rotated log files to keep (0 keeps all)")
+	fs.DurationVar(&cfg.log.fileMaxAge, "log-file-max-age", 0, "Delete rotated log files older than this (0 keeps all)")
+	fs.StringVar(&cfg.log.syslog, "log-syslog", "", "Send logs to syslog at this address, e.g. udp://localhost:514, tcp://localhost:601 or unix:///dev/log (empty disables)")
+	fs.StringVar(&cfg.log.syslogFacility, "log-syslog-facility", "local0", "Syslog facility of log messages")
+
+	// read db connection pool settings from flags
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	fs.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max idle connection time")
+	fs.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", data.DefaultQueryTimeout, "PostgreSQL timeout for a single query")
+	// mailer settings
+	fs.StringVar(&cfg.mailer.transport, "mailer", "smtp", "Mailer transport (smtp|file|log)")
+	fs.StringVar(&cfg.mailer.dir, "mailer-dir", "./tmp/mail", "Directory for .eml files written by the file mailer")
+	// outbox worker settings
+	fs.DurationVar(&cfg.outbox.interval, "outbox-interval", 5*time.Second, "Interval between checks for due emails in the outbox")
+	fs.IntVar(&cfg.outbox.maxAttempts, "outbox-max-attempts", 5, "Delivery attempts before an email is marked failed")
+	fs.DurationVar(&cfg.outbox.backoff, "outbox-backoff", 30*time.Second, "Delay before the first retry, doubled for every further attempt")
+	// job queue settings
+	fs.IntVar(&cfg.jobs.concurrency, "jobs-concurrency", 4, "Number of background jobs run at the same time")
+	fs.DurationVar(&cfg.jobs.pollInterval, "jobs-poll-interval", time.Second, "Interval between checks for due jobs when the queue is empty")
+	fs.IntVar(&cfg.jobs.maxAttempts, "jobs-max-attempts", 5, "Attempts before a background job is marked failed")
+	fs.DurationVar(&cfg.jobs.backoff, "jobs-backoff", 10*time.Second, "Delay before the first retry of a job, doubled for every further attempt")
+	// maintenance scheduler settings
+	fs.DurationVar(&cfg.maintenance.tokensInterval, "cleanup-tokens-interval", time.Hour, "Interval between purges of expired tokens (0 disables)")
+	fs.DurationVar(&cfg.maintenance.usersInterval, "cleanup-users-interval", 24*time.Hour, "Interval between purges of unactivated users")
+	fs.IntVar(&cfg.maintenance.unactivatedDays, "cleanup-unactivated-days", 0, "Delete users not activated after this many days (0 disables)")
+	// mailtrap settings
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "", "Mailtrap Host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 2525, "Mailtrap port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "Mailtrap Username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "Mailtrap Password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "GreenLight <no-reply@greenlight.com>", "Mailtrap Sender")
+	// read limiter settings from flag vars
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	fs.Var((*fieldsValue)(&cfg.cors.trustedOrigins), "cors-trusted-origins", "Trusted CORS origins (space separated)")
+	fs.DurationVar(&cfg.cache.ttl, "cache-ttl", time.Minute, "Time to cache permissions and token lookups (0 disables caching)")
+	fs.StringVar(&cfg.users.defaultRole, "default-role", "viewer", "Role assigned to new users at signup")
+
+	fs.StringVar(&cfg.debug.addr, "debug-addr", "", "Serve /metrics and /debug endpoints without authentication on this loopback address, e.g. localhost:4001 (empty serves them on the main port to users with debug:read)")
+
+	fs.StringVar(&cfg.tracing.exporter, "tracing-exporter", "none", "Exporter for trace spans (none|stdout|otlp)")
+	fs.StringVar(&cfg.tracing.otlpEndpoint, "tracing-otlp-endpoint", "http://localhost:4318", "OTLP/HTTP collector URL used by the otlp exporter")
+	fs.Float64Var(&cfg.tracing.sampleRatio, "tracing-sample-ratio", 1, "Fraction of new traces which are recorded")
+
+	return fs
+}
+
+// loadSettings reads settings from, in order of precedence, the command line
+// args, GREENLIGHT_* environment variables, the config file and the defaults
+// a variable named like GREENLIGHT_SMTP_PASSWORD_FILE sets -smtp-password to
+// the contents of the file it names, e.g. a mounted secret
+func loadSettings(args []string, lookupEnv func(string) (string, bool)) (*settings, error) {
+	s := &settings{sources: make(map[string]string)}
+	s.flags = newFlagSet(&s.config)
+	commands := []string{"config", "print-config", "version"}
+	s.flags.StringVar(&s.configFile, "config", "", "Read settings from this file of \"name = value\" lines, overridden by environment variables and flags (env GREENLIGHT_CONFIG)")
+	s.flags.BoolVar(&s.printConfig, "print-config", false, "Print the settings with secrets redacted and exit")
+	s.flags.BoolVar(&s.version, "version", false, "Display version and exit")
+
+	err := s.flags.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, errUsage
+	}
+	s.flags.VisitAll(func(f *flag.Flag) {
+		s.sources[f.Name] = sourceDefault
+	})
+	s.flags.Visit(func(f *flag.Flag) {
+		s.sources[f.Name] = sourceFlag
+	})
+	for _, name := range commands {
+		delete(s.sources, name)
+	}
+
+	if s.configFile == "" {
+		s.configFile, _ = lookupEnv(envPrefix + "CONFIG")
+	}
+	if s.configFile != "" {
+		err = s.readFile(s.configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = s.readEnv(lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// set a flag from a source other than the command line, which takes precedence
+func (s *settings) set(name, value, source string) error {
+	current, known := s.sources[name]
+	if !known {
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	if current == sourceFlag {
+		return nil
+	}
+	err := s.flags.Set(name, value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", name, err)
+	}
+	s.sources[name] = source
+	return nil
+}
+
+// read a config file of "name = value" lines, names are flag names without
+// the dash, values may be quoted and # starts a comment
+func (s *settings) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			return fmt.Errorf("%s:%d: expected name = value", path, n)
+		}
+		value, err = parseValue(value)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		err = s.set(strings.TrimSpace(name), value, sourceFile)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// parse a value which is optionally quoted and followed by a # comment
+func parseValue(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, `"`) {
+		value, _, _ := strings.Cut(s, " #")
+		return strings.TrimSpace(value), nil
+	}
+	quoted, err := strconv.QuotedPrefix(s)
+	if err != nil {
+		return "", errors.New("invalid quoted value")
+	}
+	if rest := strings.TrimSpace(s[len(quoted):]); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", errors.New("unexpected text after quoted value")
+	}
+	return strconv.Unquote(quoted)
+}
+
+// read GREENLIGHT_* and GREENLIGHT_*_FILE variables of every setting
+func (s *settings) readEnv(lookupEnv func(string) (string, bool)) error {
+	for _, name := range s.names() {
+		key := envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		value, found := lookupEnv(key)
+		path, fromFile := lookupEnv(key + "_FILE")
+		switch {
+		case found && fromFile:
+			return fmt.Errorf("both %s and %s_FILE are set", key, key)
+		case fromFile:
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %w", key, err)
+			}
+			// files usually end with a newline which is not part of the secret
+			value = strings.TrimRight(string(b), "\r\n")
+		case !found:
+			continue
+		}
+		err := s.set(name, value, sourceEnv)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// names of all settings, sorted
+func (s *settings) names() []string {
+	names := make([]string, 0, len(s.sources))
+	for name := range s.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// returns the value of every setting of cfg by flag name
+func configValues(cfg config) map[string]string {
+	var c config
+	fs := newFlagSet(&c)
+	// the flags were bound to c with their defaults, replace them by cfg
+	c = cfg
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	return values
+}
+
+// print writes the settings in the format of the config file, with the
+// source of every setting which is not a default
+func (s *settings) print(w io.Writer) {
+	for _, name := range s.names() {
+		value := s.flags.Lookup(name).Value.String()
+		if secretSettings[name] && value != "" {
+			value = "[redacted]"
+		}
+		if value == "" || strings.ContainsAny(value, " \t#\"") {
+			value = strconv.Quote(value)
+		}
+		if source := s.sources[name]; source != sourceDefault {
+			fmt.Fprintf(w, "%s = %s # %s\n", name, value, source)
+		} else {
+			fmt.Fprintf(w, "%s = %s\n", name, value)
+		}
+	}
+}
+
+// validate checks settings which are valid values of their type but can't
+// be used, all problems are reported at once
+func (cfg config) validate() error {
+	v := validator.New()
+
+	v.Check(0 < cfg.port && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.In(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+	v.Check(validator.In(cfg.storage, "postgres", "memory"), "storage", "must be postgres or memory")
+
+	if cfg.storage == "postgres" {
+		v.Check(cfg.db.dsn != "", "db-dsn", "must be provided for postgres storage")
+		v.Check(cfg.db.maxOpenConns >= 1, "db-max-open-conns", "must be at least 1")
+		v.Check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+		_, err := time.ParseDuration(cfg.db.maxIdleTime)
+		v.Check(err == nil, "db-max-idle-time", "must be a duration, e.g. 15m")
+		v.Check(cfg.db.queryTimeout >= 0, "db-query-timeout", "must not be negative")
+	}
+
+	v.Check(cfg.log.stdout || cfg.log.file != "" || cfg.log.syslog != "", "log-stdout", "must be enabled without -log-file or -log-syslog")
+	v.Check(cfg.log.fileMaxSize >= 0, "log-file-max-size", "must not be negative")
+	v.Check(cfg.log.fileRotateInterval >= 0, "log-file-rotate-interval", "must not be negative")
+	v.Check(cfg.log.fileMaxBackups >= 0, "log-file-max-backups", "must not be negative")
+	v.Check(cfg.log.fileMaxAge >= 0, "log-file-max-age", "must not be negative")
+	if cfg.log.syslog != "" {
+		_, err := jsonlog.ParseSyslogURL(cfg.log.syslog)
+		v.Check(err == nil, "log-syslog", "must be a udp://, tcp:// or unix:// address")
+		_, err = jsonlog.ParseFacility(cfg.log.syslogFacility)
+		v.Check(err == nil, "log-syslog-facility", "must be a syslog facility, e.g. local0")
+	}
+
+	v.Check(validator.In(cfg.mailer.transport, "smtp", "file", "log"), "mailer", "must be smtp, file or log")
+	if cfg.mailer.transport == "smtp" {
+		v.Check(cfg.smtp.host != "", "smtp-host", "must be provided for the smtp mailer")
+		v.Check(0 < cfg.smtp.port && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+	}
+	v.Check(cfg.outbox.interval > 0, "outbox-interval", "must be positive")
+	v.Check(cfg.outbox.maxAttempts >= 1, "outbox-max-attempts", "must be at least 1")
+	v.Check(cfg.outbox.backoff >= 0, "outbox-backoff", "must not be negative")
+
+	v.Check(cfg.jobs.concurrency >= 1, "jobs-concurrency", "must be at least 1")
+	v.Check(cfg.jobs.pollInterval > 0, "jobs-poll-interval", "must be positive")
+	v.Check(cfg.jobs.maxAttempts >= 1, "jobs-max-attempts", "must be at least 1")
+	v.Check(cfg.jobs.backoff >= 0, "jobs-backoff", "must not be negative")
+
+	v.Check(cfg.maintenance.tokensInterval >= 0, "cleanup-tokens-interval", "must not be negative")
+	v.Check(cfg.maintenance.usersInterval > 0, "cleanup-users-interval", "must be positive")
+	v.Check(cfg.maintenance.unactivatedDays >= 0, "cleanup-unactivated-days", "must not be negative")
+
+	if cfg.limiter.enabled {
+		v.Check(cfg.limiter.rps > 0, "limiter-rps", "must be positive")
+		v.Check(cfg.limiter.burst >= 1, "limiter-burst", "must be at least 1")
+	}
+	for _, origin := range cfg.cors.trustedOrigins {
+		u, err := url.Parse(origin)
+		v.Check(err == nil && u.Scheme != "" && u.Host != "", "cors-trusted-origins", "must only contain origins like https://example.com")
+	}
+	v.Check(cfg.cache.ttl >= 0, "cache-ttl", "must not be negative")
+	// the debug listener serves its endpoints without authentication
+	if cfg.debug.addr != "" {
+		v.Check(isLoopbackAddr(cfg.debug.addr), "debug-addr", "must be a loopback address like localhost:4001")
+	}
+	v.Check(cfg.users.defaultRole != "", "default-role", "must be provided")
+
+	v.Check(validator.In(cfg.tracing.exporter, "none", "stdout", "otlp"), "tracing-exporter", "must be none, stdout or otlp")
+	v.Check(0 <= cfg.tracing.sampleRatio && cfg.tracing.sampleRatio <= 1, "tracing-sample-ratio", "must be between 0 and 1")
+
+	if v.Valid() {
+		return nil
+	}
+	keys := make([]string, 0, len(v.Errors))
+	for key := range v.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	errs := make([]error, 0, len(keys))
+	for _, key := range keys {
+		errs = append(errs, fmt.Errorf("-%s %s", key, v.Errors[key]))
+	}
+	return errors.Join(errs...)
+}
+
+// reports whether addr is a host:port whose host only resolves to loopback
+// addresses, an empty host listens on all interfaces
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return false
+	}
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			return false
+		}
+	}
+	return true
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/anukuljoshi/greenlight/internal/data"
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
 )
 
 type ContextKey string
@@ -12,8 +13,15 @@ type ContextKey string
 const UserContextKey = ContextKey("user")
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if info, ok := r.Context().Value(RequestInfoContextKey).(*requestInfo); ok {
+		info.user = user
+	}
 	ctx := context.WithValue(r.Context(), UserContextKey, user)
-	return r.WithContext(ctx)
+	r = r.WithContext(ctx)
+	if !user.IsAnonymous() {
+		r = app.contextSetLogger(r, app.contextGetLogger(r).With(jsonlog.Int64("user_id", user.ID)))
+	}
+	return r
 }
 
 func (app *application) contextGetUser(r *http.Request) *data.User {
@@ -23,3 +31,59 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// store a logger with fields bound to the request, e.g. the request id
+func (app *application) contextSetLogger(r *http.Request, logger *jsonlog.Logger) *http.Request {
+	return r.WithContext(jsonlog.NewContext(r.Context(), logger))
+}
+
+// returns the logger of the request, or app.logger outside of a request
+func (app *application) contextGetLogger(r *http.Request) *jsonlog.Logger {
+	return jsonlog.FromContext(r.Context(), app.logger)
+}
+
+const RequestIDContextKey = ContextKey("request_id")
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), RequestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// returns the request id, or an empty string outside of a request
+func contextGetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(RequestIDContextKey).(string)
+	return id
+}
+
+// values which are set while a request is routed and authenticated, held by
+// pointer so that middleware running before routing can read them afterwards
+type requestInfo struct {
+	route string
+	user  *data.User
+}
+
+const RequestInfoContextKey = ContextKey("request_info")
+
+// returns r with a requestInfo in its context, an existing one is reused
+func (app *application) contextWithRequestInfo(r *http.Request) (*http.Request, *requestInfo) {
+	if info, ok := r.Context().Value(RequestInfoContextKey).(*requestInfo); ok {
+		return r, info
+	}
+	info := &requestInfo{}
+	ctx := context.WithValue(r.Context(), RequestInfoContextKey, info)
+	return r.WithContext(ctx), info
+}
+
+// returns the matched route pattern or "unmatched" if no route matched
+func (info *requestInfo) routePattern() string {
+	if info.route == "" {
+		return "unmatched"
+	}
+	return info.route
+}
+
+func contextSetRoute(r *http.Request, pattern string) {
+	if info, ok := r.Context().Value(RequestInfoContextKey).(*requestInfo); ok {
+		info.route = pattern
+	}
+}
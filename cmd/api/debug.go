@@ -0,0 +1,46 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// register /metrics, /debug/vars and the pprof handlers on router, every
+// handler is wrapped with protect
+func (app *application) debugRoutes(router router, protect func(http.HandlerFunc) http.HandlerFunc) {
+	router.HandlerFunc(http.MethodGet, "/metrics", protect(app.telemetry.registry.Handler().ServeHTTP))
+	router.HandlerFunc(http.MethodGet, "/debug/vars", protect(expvar.Handler().ServeHTTP))
+	router.HandlerFunc(http.MethodGet, "/debug/pprof/*item", protect(app.pprofHandler))
+	// pprof.Symbol also accepts symbol addresses in the request body
+	router.HandlerFunc(http.MethodPost, "/debug/pprof/*item", protect(app.pprofHandler))
+}
+
+// dispatch to the pprof handler for the requested item
+// httprouter does not allow static routes next to a catch-all parameter, so
+// this replaces the routes net/http/pprof registers on http.DefaultServeMux
+func (app *application) pprofHandler(w http.ResponseWriter, r *http.Request) {
+	switch httprouter.ParamsFromContext(r.Context()).ByName("item") {
+	case "/cmdline":
+		pprof.Cmdline(w, r)
+	case "/profile":
+		pprof.Profile(w, r)
+	case "/symbol":
+		pprof.Symbol(w, r)
+	case "/trace":
+		pprof.Trace(w, r)
+	default:
+		// serves the index page and named profiles like /debug/pprof/heap
+		pprof.Index(w, r)
+	}
+}
+
+// routes served by the separate debug listener, which is meant to be bound
+// to localhost and therefore does not authenticate requests
+func (app *application) debugListenerRoutes() http.Handler {
+	var router = newRouter()
+	app.debugRoutes(router, func(next http.HandlerFunc) http.HandlerFunc { return next })
+	return app.recoverPanic(router)
+}
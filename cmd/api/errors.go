@@ -1,18 +1,32 @@
 package main
 
-import "net/http"
+import (
+	"errors"
+	"net/http"
+
+	"github.com/anukuljoshi/greenlight/internal/data"
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+)
+
+// non-standard status code used when the client closed the request before
+// a response could be sent, the response is never read but is recorded in metrics
+const statusClientClosedRequest = 499
 
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
-		"request_method": r.Method,
-		"request_url":    r.URL.String(),
-	})
+	app.contextGetLogger(r).Error(err,
+		jsonlog.String("request_method", r.Method),
+		jsonlog.String("request_url", r.URL.String()),
+	)
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
 	var data = envelope{
 		"error": message,
 	}
+	// lets clients quote the request when reporting an error
+	if id := contextGetRequestID(r.Context()); id != "" {
+		data["request_id"] = id
+	}
 	var err = app.writeJSON(w, status, data, nil)
 	if err != nil {
 		app.logError(r, err)
@@ -21,6 +35,11 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 }
 
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	// a canceled query is not a server error, respond accordingly without logging
+	if errors.Is(err, data.ErrCanceled) {
+		app.requestCanceledResponse(w, r)
+		return
+	}
 	app.logError(r, err)
 	var message = http.StatusText(http.StatusInternalServerError)
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
@@ -54,6 +73,12 @@ func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+func (app *application) maintenanceModeResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "120")
+	message := "the server is down for maintenance, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -79,3 +104,8 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account does not have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) requestCanceledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the request was canceled"
+	app.errorResponse(w, r, statusClientClosedRequest, message)
+}
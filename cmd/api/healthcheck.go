@@ -5,8 +5,12 @@ import (
 )
 
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	var status = "available"
+	if app.currentConfig().maintenanceMode {
+		status = "maintenance"
+	}
 	var data = envelope{
-		"status": "available",
+		"status": status,
 		"system_info": map[string]string{
 			"environment": app.config.env,
 			"version":     version,
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealthcheck(t *testing.T) {
+	t.Parallel()
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	resp := ts.do(t, http.MethodGet, "/v1/healthcheck", "", nil)
+	if resp.status != http.StatusOK {
+		t.Fatalf("got status %d; want %d", resp.status, http.StatusOK)
+	}
+	if got := field(resp.body, "status"); got != "available" {
+		t.Errorf("got status %q; want %q", got, "available")
+	}
+	if got := field(resp.body, "system_info", "environment"); got != "development" {
+		t.Errorf("got environment %q; want %q", got, "development")
+	}
+}
+
+func TestDebugVars(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	viewer := ts.createUser(t, m, "viewer@example.com")
+	debugger := ts.createUser(t, m, "debugger@example.com", "debug:read")
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"Anonymous", "", http.StatusUnauthorized},
+		{"Without permission", viewer, http.StatusForbidden},
+		{"With debug:read", debugger, http.StatusOK},
+	}
+	for _, tt := range tests {
+		resp := ts.do(t, http.MethodGet, "/debug/vars", tt.token, nil)
+		if resp.status != tt.wantStatus {
+			t.Errorf("%s: got status %d; want %d", tt.name, resp.status, tt.wantStatus)
+			continue
+		}
+		if _, ok := resp.body["memstats"]; tt.wantStatus == http.StatusOK && !ok {
+			t.Errorf("missing memstats in %v", resp.body)
+		}
+	}
+}
+
+func TestPprof(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	debugger := ts.createUser(t, m, "debugger@example.com", "debug:read")
+
+	if res, _ := ts.get(t, "/debug/pprof/", ""); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous: got status %d; want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+	tests := []struct {
+		path     string
+		wantBody string
+	}{
+		{"/debug/pprof/", "goroutine"},
+		{"/debug/pprof/goroutine?debug=1", "goroutine profile"},
+		{"/debug/pprof/cmdline", "api.test"},
+	}
+	for _, tt := range tests {
+		res, body := ts.get(t, tt.path, debugger)
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s: got status %d; want %d", tt.path, res.StatusCode, http.StatusOK)
+			continue
+		}
+		if !strings.Contains(body, tt.wantBody) {
+			t.Errorf("%s: missing %q in body", tt.path, tt.wantBody)
+		}
+	}
+}
+
+func TestDebugListener(t *testing.T) {
+	t.Parallel()
+	app, _ := newTestApplication(t)
+	app.config.debug.addr = "localhost:0"
+	ts := newTestServer(t, app)
+
+	// the main port no longer serves metrics and debug endpoints
+	for _, path := range []string{"/metrics", "/debug/vars"} {
+		if res, _ := ts.get(t, path, ""); res.StatusCode != http.StatusNotFound {
+			t.Errorf("main server %s: got status %d; want %d", path, res.StatusCode, http.StatusNotFound)
+		}
+	}
+
+	debug := httptest.NewServer(app.debugListenerRoutes())
+	t.Cleanup(debug.Close)
+	for _, path := range []string{"/metrics", "/debug/vars", "/debug/pprof/"} {
+		res, err := debug.Client().Get(debug.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("debug server %s: got status %d; want %d", path, res.StatusCode, http.StatusOK)
+		}
+	}
+}
+
+func TestNotFoundAndMethodNotAllowed(t *testing.T) {
+	t.Parallel()
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	if resp := ts.do(t, http.MethodGet, "/v1/missing", "", nil); resp.status != http.StatusNotFound {
+		t.Errorf("got status %d; want %d", resp.status, http.StatusNotFound)
+	}
+	if resp := ts.do(t, http.MethodPost, "/v1/healthcheck", "", nil); resp.status != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d; want %d", resp.status, http.StatusMethodNotAllowed)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/anukuljoshi/greenlight/internal/data"
 	"github.com/anukuljoshi/greenlight/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
@@ -84,6 +86,22 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	return nil
 }
 
+// returns the plaintext token from a "Bearer <token>" Authorization header
+func (app *application) readBearerToken(r *http.Request) (string, error) {
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	// check if Auth header is in correct format
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return "", errors.New("invalid authorization header")
+	}
+	token := headerParts[1]
+	// check if token is valid
+	v := validator.New()
+	if data.ValidateTokenPlainText(v, token); !v.Valid() {
+		return "", errors.New("invalid authentication token")
+	}
+	return token, nil
+}
+
 // returns a string value with key from query params if key is present else return defaultValue
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
 	s := qs.Get(key)
@@ -116,20 +134,11 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
-// helper function to run background tasks
-// it recovers from panic and logs error instead of terminating application
-func (app *application) background(fn func()) {
-	// increment wait group counter before running goroutine
-	app.wg.Add(1)
-	go func() {
-		// defer decrement of wait group counter before goroutine returns
-		defer app.wg.Done()
-		defer func() {
-			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
-			}
-		}()
-		// execute the function passes a parameter
-		fn()
-	}()
+// returns the published expvar.Map with name, creating it if needed
+// expvar panics if a name is published twice
+func expvarMap(name string) *expvar.Map {
+	if v, ok := expvar.Get(name).(*expvar.Map); ok {
+		return v
+	}
+	return expvar.NewMap(name)
 }
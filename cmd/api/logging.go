@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+	"github.com/anukuljoshi/greenlight/internal/validator"
+)
+
+// returns the writer for log entries of all configured outputs and a function
+// which closes the outputs
+func openLogOutput(cfg config) (io.Writer, func() error, error) {
+	// check the syslog settings before any output is opened
+	var syslogConfig jsonlog.SyslogConfig
+	if cfg.log.syslog != "" {
+		var err error
+		syslogConfig, err = jsonlog.ParseSyslogURL(cfg.log.syslog)
+		if err != nil {
+			return nil, nil, err
+		}
+		syslogConfig.Facility, err = jsonlog.ParseFacility(cfg.log.syslogFacility)
+		if err != nil {
+			return nil, nil, err
+		}
+		syslogConfig.AppName = "greenlight"
+	}
+
+	var writers []io.Writer
+	var closers []io.Closer
+	closeAll := func() error {
+		var errs []error
+		for _, c := range closers {
+			errs = append(errs, c.Close())
+		}
+		return errors.Join(errs...)
+	}
+
+	if cfg.log.stdout {
+		writers = append(writers, os.Stdout)
+	}
+	if cfg.log.file != "" {
+		file, err := jsonlog.NewRotatingFile(cfg.log.file, jsonlog.RotateConfig{
+			MaxSize:    int64(cfg.log.fileMaxSize) * 1024 * 1024,
+			Interval:   cfg.log.fileRotateInterval,
+			MaxBackups: cfg.log.fileMaxBackups,
+			MaxAge:     cfg.log.fileMaxAge,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		writers = append(writers, file)
+		closers = append(closers, file)
+	}
+	if cfg.log.syslog != "" {
+		syslog, err := jsonlog.NewSyslogWriter(syslogConfig)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		writers = append(writers, syslog)
+		closers = append(closers, syslog)
+	}
+	if len(writers) == 0 {
+		return nil, nil, errors.New("no log output, enable -log-stdout, -log-file or -log-syslog")
+	}
+	return jsonlog.MultiWriter(writers...), closeAll, nil
+}
+
+// return the current minimum level of the logger
+func (app *application) showLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"level": strings.ToLower(app.logger.Level().String())}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// change the minimum level of the logger until the next restart, loggers
+// bound to requests share the level of app.logger
+func (app *application) updateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Level string `json:"level"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	level, err := jsonlog.ParseLevel(input.Level)
+	v.Check(err == nil, "level", "must be debug, info, warn, error, fatal or off")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	previous := app.logger.Level()
+	logChange := func() {
+		app.contextGetLogger(r).Warn("log level changed",
+			jsonlog.String("from", strings.ToLower(previous.String())),
+			jsonlog.String("to", strings.ToLower(level.String())),
+		)
+	}
+	// written at warn under the more verbose of the two levels, so the change
+	// is logged unless both the old and the new level drop warnings
+	if level < previous {
+		app.logger.SetLevel(level)
+		logChange()
+	} else {
+		logChange()
+		app.logger.SetLevel(level)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"level": strings.ToLower(level.String())}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+)
+
+func TestLogLevel(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	viewer := ts.createUser(t, m, "viewer@example.com")
+	admin := ts.createUser(t, m, "admin@example.com", "users:admin")
+
+	var buf bytes.Buffer
+	app.logger = jsonlog.New(&buf, jsonlog.LevelInfo)
+
+	resp := ts.do(t, http.MethodGet, "/v1/admin/log-level", viewer, nil)
+	if resp.status != http.StatusForbidden {
+		t.Fatalf("got status %d for a viewer; want %d", resp.status, http.StatusForbidden)
+	}
+	resp = ts.do(t, http.MethodGet, "/v1/admin/log-level", admin, nil)
+	if resp.status != http.StatusOK || field(resp.body, "level") != "info" {
+		t.Fatalf("got status %d and body %v; want level info", resp.status, resp.body)
+	}
+
+	resp = ts.do(t, http.MethodPut, "/v1/admin/log-level", admin, map[string]any{"level": "verbose"})
+	if resp.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d for an unknown level; want %d", resp.status, http.StatusUnprocessableEntity)
+	}
+
+	resp = ts.do(t, http.MethodPut, "/v1/admin/log-level", admin, map[string]any{"level": "WARN"})
+	if resp.status != http.StatusOK || field(resp.body, "level") != "warn" {
+		t.Fatalf("got status %d and body %v; want level warn", resp.status, resp.body)
+	}
+	if app.logger.Level() != jsonlog.LevelWarn {
+		t.Errorf("got logger level %s; want WARN", app.logger.Level())
+	}
+
+	// access log entries are written at info and are now dropped
+	buf.Reset()
+	ts.do(t, http.MethodGet, "/v1/healthcheck", "", nil)
+	if strings.Contains(buf.String(), "request completed") {
+		t.Errorf("got access log entry at level warn: %s", buf.String())
+	}
+
+	// the change is logged when the new or the old level drops warnings
+	for _, change := range []struct{ from, to string }{{"warn", "error"}, {"error", "debug"}} {
+		buf.Reset()
+		resp = ts.do(t, http.MethodPut, "/v1/admin/log-level", admin, map[string]any{"level": change.to})
+		if resp.status != http.StatusOK {
+			t.Fatalf("got status %d and body %v", resp.status, resp.body)
+		}
+		properties := logEntry(t, &buf, "log level changed")
+		if properties["from"] != change.from || properties["to"] != change.to {
+			t.Errorf("got change %v; want from %s to %s", properties, change.from, change.to)
+		}
+	}
+}
+
+func TestOpenLogOutput(t *testing.T) {
+	t.Parallel()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	var cfg config
+	cfg.log.file = filepath.Join(t.TempDir(), "api.log")
+	cfg.log.fileMaxSize = 1
+	cfg.log.syslog = "udp://" + pc.LocalAddr().String()
+	cfg.log.syslogFacility = "local1"
+	out, closeLog, err := openLogOutput(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonlog.New(out, jsonlog.LevelInfo).Info("started")
+	if err := closeLog(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.ReadFile(cfg.log.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(file), `"message":"started"`) {
+		t.Errorf("got log file %q", file)
+	}
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// local1 (17) * 8 + informational (6)
+	if msg := string(buf[:n]); !strings.HasPrefix(msg, "<142>1 ") || !strings.Contains(msg, " greenlight ") {
+		t.Errorf("got syslog message %q", msg)
+	}
+
+	cfg.log.syslogFacility = "local9"
+	if _, _, err := openLogOutput(cfg); err == nil {
+		t.Error("got nil error for an unknown facility")
+	}
+	var none config
+	if _, _, err := openLogOutput(none); err == nil {
+		t.Error("got nil error without any output")
+	}
+}
@@ -3,18 +3,20 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	"os"
 	"runtime"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/anukuljoshi/greenlight/internal/data"
+	"github.com/anukuljoshi/greenlight/internal/jobs"
 	"github.com/anukuljoshi/greenlight/internal/jsonlog"
 	"github.com/anukuljoshi/greenlight/internal/mailer"
+	"github.com/anukuljoshi/greenlight/internal/tracing"
 	_ "github.com/lib/pq"
 )
 
@@ -23,92 +25,125 @@ var (
 	version   string
 )
 
-// config struct to hold settings for our application
-type config struct {
-	port int
-	env  string
-	db   struct {
-		dsn          string
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  string
-	}
-	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
-	}
-	smtp struct {
-		host     string
-		port     int
-		username string
-		password string
-		sender   string
-	}
-	cors struct {
-		trustedOrigins []string
-	}
+// interface for sending templated emails, satisfied by mailer.Mailer
+type mailSender interface {
+	Send(recipient, templateFile string, data any) error
 }
 
 // application struct to hold dependencies for handlers, middlewares, helpers
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	// reloadable settings are replaced on SIGHUP while holding configMu
+	config   config
+	configMu sync.RWMutex
+	// reads the settings again on SIGHUP, nil disables reloading
+	reloadSettings func() (*settings, error)
+
+	logger    *jsonlog.Logger
+	models    data.Models
+	mailer    mailSender
+	jobs      *jobs.Queue
+	telemetry *telemetry
+	tracer    *tracing.Tracer
+	wg        sync.WaitGroup
 }
 
 func main() {
-	var cfg config
-
-	// read flag vars
-	flag.IntVar(&cfg.port, "port", 4000, "PORT for application")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
-
-	// read db connection pool settings from flags
-	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max idle connection time")
-	// mailtrap settings
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "", "Mailtrap Host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "Mailtrap port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", "", "Mailtrap Username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", "", "Mailtrap Password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "GreenLight <no-reply@greenlight.com>", "Mailtrap Sender")
-	// read limiter settings from flag vars
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
-	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(s string) error {
-		cfg.cors.trustedOrigins = strings.Fields(s)
-		return nil
-	})
-	// Create a new version boolean flag with the default value of false.
-	displayVersion := flag.Bool("version", false, "Display version and exit")
-
-	flag.Parse()
+	s, err := loadSettings(os.Args[1:], os.LookupEnv)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	cfg := s.config
 
 	// If the version flag value is true, then print out the version number and
 	// immediately exit.
-	if *displayVersion {
+	if s.version {
 		fmt.Printf("Version:\t%s\n", version)
 		fmt.Printf("Build time:\t%s\n", buildTime)
 		os.Exit(0)
 	}
 
-	// create logger
-	var logger = jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	// print the settings before validating them, so they can be checked
+	// when they are invalid
+	if s.printConfig {
+		s.print(os.Stdout)
+	}
+	err = cfg.validate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+		os.Exit(2)
+	}
+	if s.printConfig {
+		os.Exit(0)
+	}
 
-	// connect to db
-	db, err := openDB(cfg)
+	// create logger
+	out, closeLog, err := openLogOutput(cfg)
 	if err != nil {
-		logger.PrintFatal(err, nil)
+		jsonlog.New(os.Stderr, cfg.log.level).Fatal(err)
+	}
+	defer closeLog()
+	var logger = jsonlog.New(out, cfg.log.level)
+
+	// create registry for metrics served on /metrics
+	telemetry := newTelemetry()
+
+	// create tracer, spans are not recorded without an exporter
+	var exporter tracing.Exporter
+	switch cfg.tracing.exporter {
+	case "none":
+	case "stdout":
+		exporter = tracing.NewStdoutExporter(os.Stdout)
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(cfg.tracing.otlpEndpoint, "greenlight")
+	default:
+		logger.Fatal(fmt.Errorf("unknown tracing exporter %q", cfg.tracing.exporter))
+	}
+	tracer := tracing.New(exporter, logger, tracing.Config{SampleRatio: cfg.tracing.sampleRatio})
+	telemetry.registerTracer(tracer)
+
+	// create cache for permissions and token lookups
+	cache := data.NewCache(cfg.cache.ttl)
+
+	// create models for the selected storage backend
+	var models data.Models
+	switch cfg.storage {
+	case "postgres":
+		// connect to db
+		db, err := openDB(cfg)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		defer db.Close()
+
+		logger.Info("database connected")
+
+		// publish db connection pool stats
+		expvar.Publish("database", expvar.Func(func() any {
+			return db.Stats()
+		}))
+
+		telemetry.registerDB(db)
+
+		models = data.NewModels(db, cache, cfg.db.queryTimeout)
+	case "memory":
+		// in-memory storage is lost on restart, only use for development and tests
+		logger.Info("using in-memory storage")
+		models = data.NewMemoryModels()
+	default:
+		logger.Fatal(fmt.Errorf("unknown storage backend %q", cfg.storage))
 	}
-	defer db.Close()
 
-	logger.PrintInfo("database connected", nil)
+	// registration would silently assign no role if the default role is unknown
+	err = checkRole(models, cfg.users.defaultRole)
+	if err != nil {
+		logger.Fatal(err)
+	}
 
 	// publish a new variable "version" in expvar
 	expvar.NewString("version").Set(version)
@@ -118,9 +153,9 @@ func main() {
 		return runtime.NumGoroutine()
 	}))
 
-	// publish db connection pool stats
-	expvar.Publish("database", expvar.Func(func() any {
-		return db.Stats()
+	// publish cache hit and miss counters
+	expvar.Publish("cache", expvar.Func(func() any {
+		return cache.Stats()
 	}))
 
 	// publish unix timestamp
@@ -128,24 +163,69 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	// create mailer transport
+	var transport mailer.Transport
+	switch cfg.mailer.transport {
+	case "smtp":
+		transport = mailer.NewSMTPTransport(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password)
+	case "file":
+		fileTransport, err := mailer.NewFileTransport(cfg.mailer.dir)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		transport = fileTransport
+	case "log":
+		transport = mailer.NewLogTransport(logger)
+	default:
+		logger.Fatal(fmt.Errorf("unknown mailer transport %q", cfg.mailer.transport))
+	}
+
 	// create app struct
 	var app = &application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(
-			cfg.smtp.host,
-			cfg.smtp.port,
-			cfg.smtp.username,
-			cfg.smtp.password,
-			cfg.smtp.sender,
-		),
+		models: models,
+		mailer: countingMailer{
+			mailSender: mailer.NewWithTransport(transport, cfg.smtp.sender),
+			emails:     telemetry.emails,
+		},
+		jobs: jobs.New(models.Jobs, logger, jobs.Config{
+			Concurrency:  cfg.jobs.concurrency,
+			PollInterval: cfg.jobs.pollInterval,
+			MaxAttempts:  cfg.jobs.maxAttempts,
+			Backoff:      cfg.jobs.backoff,
+		}),
+		telemetry: telemetry,
+		tracer:    tracer,
+		// the environment of the process can't change, a reload picks up
+		// changes to the config file
+		reloadSettings: func() (*settings, error) {
+			return loadSettings(os.Args[1:], os.LookupEnv)
+		},
 	}
 
+	app.registerMaintenanceJobs()
+
 	err = app.serve()
 	if err != nil {
-		logger.PrintFatal(err, nil)
+		logger.Fatal(err)
+	}
+}
+
+// returns an error if there is no role with name
+func checkRole(models data.Models, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	roles, err := models.Roles.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if role.Name == name {
+			return nil
+		}
 	}
+	return fmt.Errorf("-default-role %q is not an existing role", name)
 }
 
 func openDB(cfg config) (*sql.DB, error) {
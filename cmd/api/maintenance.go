@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/anukuljoshi/greenlight/internal/data"
+	"github.com/anukuljoshi/greenlight/internal/jobs"
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+)
+
+// kinds of the jobs enqueued by the maintenance scheduler
+const (
+	jobPurgeExpiredTokens    = "maintenance.purge_expired_tokens"
+	jobPurgeUnactivatedUsers = "maintenance.purge_unactivated_users"
+)
+
+type purgeUnactivatedUsersPayload struct {
+	CreatedBefore time.Time `json:"created_before"`
+}
+
+// a maintenance job which is enqueued once every interval
+type schedule struct {
+	kind     string
+	interval time.Duration
+	payload  func() any
+}
+
+// register the handlers for maintenance jobs with the job queue
+func (app *application) registerMaintenanceJobs() {
+	app.jobs.Register(jobPurgeExpiredTokens, jobs.Handle(app.purgeExpiredTokens))
+	app.jobs.Register(jobPurgeUnactivatedUsers, jobs.Handle(app.purgeUnactivatedUsers))
+}
+
+// enqueue maintenance jobs on their configured intervals until ctx is canceled
+// a schedule with an interval of 0 is disabled
+func (app *application) runScheduler(ctx context.Context) {
+	schedules := []schedule{
+		{
+			kind:     jobPurgeExpiredTokens,
+			interval: app.config.maintenance.tokensInterval,
+			payload:  func() any { return struct{}{} },
+		},
+	}
+	if days := app.config.maintenance.unactivatedDays; days > 0 {
+		schedules = append(schedules, schedule{
+			kind:     jobPurgeUnactivatedUsers,
+			interval: app.config.maintenance.usersInterval,
+			payload: func() any {
+				return purgeUnactivatedUsersPayload{
+					CreatedBefore: time.Now().AddDate(0, 0, -days),
+				}
+			},
+		})
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range schedules {
+		if s.interval <= 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(s schedule) {
+			defer wg.Done()
+			app.runSchedule(ctx, s)
+		}(s)
+	}
+	wg.Wait()
+}
+
+func (app *application) runSchedule(ctx context.Context, s schedule) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		_, err := jobs.Enqueue(ctx, app.models.Jobs, s.kind, s.payload(), time.Time{})
+		if err != nil && !errors.Is(err, data.ErrCanceled) {
+			app.logger.Error(err, jsonlog.String("kind", s.kind))
+		}
+	}
+}
+
+// delete expired tokens of every scope
+func (app *application) purgeExpiredTokens(ctx context.Context, _ struct{}) error {
+	deleted, err := app.models.Tokens.DeleteExpired(ctx)
+	if err != nil {
+		return err
+	}
+	app.reportPurge("expired_tokens_deleted", deleted)
+	return nil
+}
+
+// delete users which never activated their account
+func (app *application) purgeUnactivatedUsers(ctx context.Context, payload purgeUnactivatedUsersPayload) error {
+	deleted, err := app.models.Users.DeleteUnactivated(ctx, payload.CreatedBefore)
+	if err != nil {
+		return err
+	}
+	app.reportPurge("unactivated_users_deleted", deleted)
+	return nil
+}
+
+// log the number of deleted records and add it to the maintenance expvar
+func (app *application) reportPurge(name string, deleted int64) {
+	expvarMap("maintenance").Add(name, deleted)
+	app.logger.Info("maintenance completed", jsonlog.Int64(name, deleted))
+}
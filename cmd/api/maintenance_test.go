@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/anukuljoshi/greenlight/internal/data"
+	"github.com/anukuljoshi/greenlight/internal/jobs"
+)
+
+func TestPurgeExpiredTokens(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	token := ts.createUser(t, m, "alice@example.com")
+	user, err := app.models.Users.GetByEmail(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopePasswordReset} {
+		_, err := app.models.Tokens.New(ctx, user.ID, -time.Minute, scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	job, err := jobs.Enqueue(ctx, app.models.Jobs, jobPurgeExpiredTokens, struct{}{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.jobs.RunDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if job, err = app.models.Jobs.Get(ctx, job.ID); err != nil || job.Status != data.JobDone {
+		t.Fatalf("got job %+v, error %v; want status %s", job, err, data.JobDone)
+	}
+	if deleted, err := app.models.Tokens.DeleteExpired(ctx); err != nil || deleted != 0 {
+		t.Errorf("got %d expired tokens left, error %v; want 0", deleted, err)
+	}
+	if resp := ts.do(t, http.MethodGet, "/v1/users/me", token, nil); resp.status != http.StatusOK {
+		t.Errorf("valid token: got status %d; want %d", resp.status, http.StatusOK)
+	}
+}
+
+func TestPurgeUnactivatedUsers(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	ts.registerUser(t, m, "Alice", "alice@example.com", "pa55word1234")
+	ts.createUser(t, m, "bob@example.com")
+
+	payload := purgeUnactivatedUsersPayload{CreatedBefore: time.Now().Add(time.Minute)}
+	_, err := jobs.Enqueue(ctx, app.models.Jobs, jobPurgeUnactivatedUsers, payload, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.jobs.RunDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		email   string
+		wantErr error
+	}{
+		{"alice@example.com", data.ErrRecordNotFound},
+		{"bob@example.com", nil},
+	}
+	for _, tt := range tests {
+		_, err := app.models.Users.GetByEmail(ctx, tt.email)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got error %v; want %v", tt.email, err, tt.wantErr)
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	t.Parallel()
+	app, _ := newTestApplication(t)
+	app.config.maintenance.tokensInterval = 10 * time.Millisecond
+	app.config.maintenance.usersInterval = 10 * time.Millisecond
+	app.config.maintenance.unactivatedDays = 30
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.runScheduler(ctx)
+	}()
+
+	// wait until both schedules enqueued a job
+	kinds := map[string]bool{}
+	for deadline := time.Now().Add(5 * time.Second); len(kinds) < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		claimed, err := app.models.Jobs.Claim(context.Background(), 10, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, job := range claimed {
+			kinds[job.Kind] = true
+		}
+	}
+	cancel()
+	<-done
+
+	for _, kind := range []string{jobPurgeExpiredTokens, jobPurgeUnactivatedUsers} {
+		if !kinds[kind] {
+			t.Errorf("no %s job enqueued", kind)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"runtime"
+
+	"github.com/anukuljoshi/greenlight/internal/metrics"
+	"github.com/anukuljoshi/greenlight/internal/tracing"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Prometheus metrics served on /metrics
+type telemetry struct {
+	registry        *metrics.Registry
+	requests        *metrics.CounterVec
+	requestDuration *metrics.HistogramVec
+	inFlight        *metrics.Gauge
+	rateLimited     *metrics.Counter
+	emails          *metrics.CounterVec
+}
+
+func newTelemetry() *telemetry {
+	r := metrics.NewRegistry()
+	t := &telemetry{
+		registry: r,
+		requests: r.NewCounterVec(
+			"greenlight_http_requests_total",
+			"HTTP requests by method, route pattern and status code.",
+			"method", "route", "status",
+		),
+		requestDuration: r.NewHistogramVec(
+			"greenlight_http_request_duration_seconds",
+			"HTTP request latency by method and route pattern.",
+			metrics.DefBuckets,
+			"method", "route",
+		),
+		inFlight: r.NewGauge(
+			"greenlight_http_requests_in_flight",
+			"HTTP requests currently being served.",
+		),
+		rateLimited: r.NewCounter(
+			"greenlight_rate_limit_rejections_total",
+			"Requests rejected by the rate limiter.",
+		),
+		emails: r.NewCounterVec(
+			"greenlight_emails_total",
+			"Emails passed to the mailer by template and result (sent or failed).",
+			"template", "result",
+		),
+	}
+	r.NewGaugeFunc("greenlight_goroutines", "Number of goroutines.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	return t
+}
+
+// export connection pool statistics of db
+func (t *telemetry) registerDB(db *sql.DB) {
+	stat := func(fn func(s sql.DBStats) float64) func() float64 {
+		return func() float64 { return fn(db.Stats()) }
+	}
+	r := t.registry
+	r.NewGaugeFunc("greenlight_db_max_open_connections", "Maximum number of open connections to the database.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	r.NewGaugeFunc("greenlight_db_open_connections", "Established connections, both in use and idle.",
+		stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	r.NewGaugeFunc("greenlight_db_in_use_connections", "Connections currently in use.",
+		stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	r.NewGaugeFunc("greenlight_db_idle_connections", "Idle connections.",
+		stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	r.NewCounterFunc("greenlight_db_wait_count_total", "Connections waited for.",
+		stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	r.NewCounterFunc("greenlight_db_wait_duration_seconds_total", "Time spent waiting for new connections.",
+		stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	r.NewCounterFunc("greenlight_db_max_idle_closed_total", "Connections closed due to the idle connection limit.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	r.NewCounterFunc("greenlight_db_max_idle_time_closed_total", "Connections closed due to the idle time limit.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+	r.NewCounterFunc("greenlight_db_max_lifetime_closed_total", "Connections closed due to the connection lifetime limit.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
+
+// export the number of spans the tracer dropped
+func (t *telemetry) registerTracer(tracer *tracing.Tracer) {
+	t.registry.NewCounterFunc("greenlight_trace_spans_dropped_total", "Spans dropped because the export queue was full.",
+		func() float64 { return float64(tracer.Dropped()) })
+}
+
+// router records the pattern of the matched route in the request context,
+// so that metrics are labelled by pattern instead of by raw URL
+type router struct {
+	*httprouter.Router
+}
+
+func newRouter() router {
+	return router{httprouter.New()}
+}
+
+func (rt router) Handler(method, path string, handler http.Handler) {
+	rt.Router.Handler(method, path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contextSetRoute(r, path)
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+func (rt router) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	rt.Handler(method, path, handler)
+}
+
+// mailSender which counts sent and failed emails
+type countingMailer struct {
+	mailSender
+	emails *metrics.CounterVec
+}
+
+func (m countingMailer) Send(recipient, templateFile string, data any) error {
+	err := m.mailSender.Send(recipient, templateFile, data)
+	result := "sent"
+	if err != nil {
+		result = "failed"
+	}
+	m.emails.WithLabelValues(templateFile, result).Inc()
+	return err
+}
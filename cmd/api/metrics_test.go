@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// returns the body served on /metrics to a user with debug:read
+func (ts *testServer) scrape(t *testing.T, token string) string {
+	t.Helper()
+	res, body := ts.get(t, "/metrics", token)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d; want %d", res.StatusCode, http.StatusOK)
+	}
+	if got := res.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("got Content-Type %q", got)
+	}
+	return body
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	token := ts.createUser(t, m, "alice@example.com", "movies:read", "debug:read")
+
+	for _, id := range []int{1, 2, 3} {
+		ts.do(t, http.MethodGet, fmt.Sprintf("/v1/movies/%d", id), token, nil)
+	}
+	ts.do(t, http.MethodGet, "/v1/missing", "", nil)
+
+	m.fail(errors.New("smtp unavailable"))
+	ts.do(t, http.MethodPost, "/v1/tokens/password-reset", "", map[string]string{
+		"email": "alice@example.com",
+	})
+
+	// metrics are only served to users with debug:read
+	if res, _ := ts.get(t, "/metrics", ""); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous: got status %d; want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+	body := ts.scrape(t, token)
+	wantLines := []string{
+		`greenlight_http_requests_total{method="GET",route="/v1/movies/:id",status="404"} 3`,
+		`greenlight_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`greenlight_http_request_duration_seconds_count{method="GET",route="/v1/movies/:id"} 3`,
+		`greenlight_http_requests_in_flight 1`,
+		`greenlight_emails_total{template="user_welcome.tmpl.html",result="sent"} 1`,
+		`greenlight_emails_total{template="token_password_reset.tmpl.html",result="failed"} 1`,
+	}
+	for _, line := range wantLines {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `route="/v1/movies/1"`) {
+		t.Errorf("raw url used as route label in\n%s", body)
+	}
+}
+
+func TestMetricsRateLimited(t *testing.T) {
+	t.Parallel()
+	app, _ := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 1
+	app.config.limiter.burst = 1
+	ts := newTestServer(t, app)
+
+	for i := 0; i < 3; i++ {
+		ts.do(t, http.MethodGet, "/v1/healthcheck", "", nil)
+	}
+	if got := app.telemetry.rateLimited.Value(); got != 2 {
+		t.Errorf("got %v rate limited requests; want 2", got)
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"expvar"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -11,19 +13,128 @@ import (
 	"time"
 
 	"github.com/anukuljoshi/greenlight/internal/data"
-	"github.com/anukuljoshi/greenlight/internal/validator"
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+	"github.com/anukuljoshi/greenlight/internal/tracing"
 	"github.com/felixge/httpsnoop"
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 )
 
+// minimum time between updates of a token's last used time
+const sessionTouchInterval = time.Minute
+
+// longest X-Request-ID accepted from clients
+const maxRequestIDLength = 128
+
+// middleware to accept the X-Request-ID of a request or generate a new one,
+// the id is stored in the request context and echoed in the response
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+		r = app.contextSetLogger(r, app.logger.With(jsonlog.String("request_id", id)))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reports whether id is safe to echo in headers and logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.ContainsRune("-_.:+/=", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// returns 16 random bytes encoded as hex
+func newRequestID() string {
+	var b [16]byte
+	// crypto/rand.Read does not fail on supported platforms
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// middleware to record a server span for each request, which continues the
+// trace of a valid traceparent header
+func (app *application) traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, err := tracing.ParseTraceparent(r.Header.Get("traceparent")); err == nil {
+			ctx = tracing.ContextWithRemoteParent(ctx, sc)
+		}
+		ctx, span := app.tracer.Start(ctx, r.Method, tracing.WithKind(tracing.KindServer))
+		defer span.End()
+
+		r, info := app.contextWithRequestInfo(r.WithContext(ctx))
+		if sc := span.SpanContext(); sc.IsValid() {
+			r = app.contextSetLogger(r, app.contextGetLogger(r).With(jsonlog.String("trace_id", sc.TraceID.String())))
+		}
+		metrics := httpsnoop.CaptureMetrics(next, w, r)
+
+		// the route is only known once the request was routed
+		route := info.routePattern()
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(
+			tracing.String("http.request.method", r.Method),
+			tracing.String("http.route", route),
+			tracing.Int("http.response.status_code", metrics.Code),
+			tracing.String("request_id", contextGetRequestID(ctx)),
+		)
+		if metrics.Code >= http.StatusInternalServerError {
+			span.RecordError(errors.New(http.StatusText(metrics.Code)))
+		}
+	})
+}
+
+// middleware to write one access log entry per request once it is served
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, info := app.contextWithRequestInfo(r)
+		metrics := httpsnoop.CaptureMetrics(next, w, r)
+
+		// the request id and trace id are bound to the logger of the request,
+		// the user is only known once the request was authenticated
+		fields := []jsonlog.Field{
+			jsonlog.String("method", r.Method),
+			jsonlog.String("route", info.routePattern()),
+			jsonlog.Int("status", metrics.Code),
+			jsonlog.Int64("bytes", metrics.Written),
+			jsonlog.Duration("duration", metrics.Duration),
+			jsonlog.String("ip", realip.FromRequest(r)),
+		}
+		if info.user != nil && !info.user.IsAnonymous() {
+			fields = append(fields, jsonlog.Int64("user_id", info.user.ID))
+		}
+		app.contextGetLogger(r).Info("request completed", fields...)
+	})
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// create a deferred function which will run in the event of panic as Go unwinds stack
 		defer func() {
 			if err := recover(); err != nil {
 				w.Header().Set("Connection", "close")
-				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
+				// the stack trace is only useful for panics, other errors are
+				// logged without one
+				app.contextGetLogger(r).Error(fmt.Errorf("%s", err),
+					jsonlog.String("request_method", r.Method),
+					jsonlog.String("request_url", r.URL.String()),
+					jsonlog.Stack(),
+				)
+				message := http.StatusText(http.StatusInternalServerError)
+				app.errorResponse(w, r, http.StatusInternalServerError, message)
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -60,7 +171,7 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 	// returning function is a closure which closes over the limiter
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// only check if rate limiter is enabled
-		if app.config.limiter.enabled {
+		if limiter := app.currentConfig().limiter; limiter.enabled {
 			// use realip.FromRequest() to get client's real IP address
 			ip := realip.FromRequest(r)
 			// lock mutex to prevent this code from running concurrently
@@ -70,16 +181,22 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 			if _, found := clients[ip]; !found {
 				clients[ip] = &client{
 					limiter: rate.NewLimiter(
-						rate.Limit(app.config.limiter.rps),
-						app.config.limiter.burst,
+						rate.Limit(limiter.rps),
+						limiter.burst,
 					),
 				}
 			}
+			// limits may have been changed by a reload since the client was first seen
+			if clients[ip].limiter.Limit() != rate.Limit(limiter.rps) || clients[ip].limiter.Burst() != limiter.burst {
+				clients[ip].limiter.SetLimit(rate.Limit(limiter.rps))
+				clients[ip].limiter.SetBurst(limiter.burst)
+			}
 			// update lastSeen time
 			clients[ip].lastSeen = time.Now()
 
 			if !clients[ip].limiter.Allow() {
 				mu.Unlock()
+				app.telemetry.rateLimited.Inc()
 				app.rateLimitExceededResponse(w, r)
 				return
 			}
@@ -92,6 +209,36 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 // authentication middleware
 func (app *application) authenticate(next http.Handler) http.Handler {
+	// last time each token's last_used_at was written, used to throttle
+	// updates to at most one per token every sessionTouchInterval
+	// tokens are keyed by their hash like in the tokens table, so the map
+	// holds no usable credentials
+	var (
+		mu        sync.Mutex
+		touched   = make(map[[sha256.Size]byte]time.Time)
+		lastPrune = time.Now()
+	)
+	shouldTouch := func(token string) bool {
+		hash := sha256.Sum256([]byte(token))
+		mu.Lock()
+		defer mu.Unlock()
+		now := time.Now()
+		// periodically remove entries which are past the throttle interval
+		if now.Sub(lastPrune) > sessionTouchInterval {
+			for t, at := range touched {
+				if now.Sub(at) > sessionTouchInterval {
+					delete(touched, t)
+				}
+			}
+			lastPrune = now
+		}
+		if at, found := touched[hash]; found && now.Sub(at) < sessionTouchInterval {
+			return false
+		}
+		touched[hash] = now
+		return true
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any
 		// caches that the response may vary based on the value of the Authorization
@@ -107,21 +254,13 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// extract token from Authorization header
-		headerParts := strings.Split(authorizationHeader, " ")
-		// check if Auth header is in correct format
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.invalidAuthenticationTokenResponse(w, r)
-			return
-		}
-		token := headerParts[1]
-		// check if token is valid
-		v := validator.New()
-		if data.ValidateTokenPlainText(v, token); !v.Valid() {
+		token, err := app.readBearerToken(r)
+		if err != nil {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 		// get user with token
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -131,6 +270,13 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
+		// record token usage, failures are logged but do not fail the request
+		if shouldTouch(token) {
+			err = app.models.Tokens.Touch(r.Context(), data.ScopeAuthentication, token)
+			if err != nil {
+				app.logError(r, err)
+			}
+		}
 		// set user in request context
 		r = app.contextSetUser(r, user)
 		// call next handler in chain
@@ -170,7 +316,7 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	// instead of returning handler store in fn
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -194,17 +340,19 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		w.Header().Set("Vary", "Access-Control-Request-Method")
 
 		origin := r.Header.Get("Origin")
+		trustedOrigins := app.currentConfig().cors.trustedOrigins
 		// set Access-Control-Allow-Origin to Origin header if it matches one of our trustedOrigins
-		if origin != "" && len(app.config.cors.trustedOrigins) > 0 {
-			for i := range app.config.cors.trustedOrigins {
-				if origin == app.config.cors.trustedOrigins[i] {
+		if origin != "" && len(trustedOrigins) > 0 {
+			for i := range trustedOrigins {
+				if origin == trustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 					// check if request had http method OPTIONS, and contains the
 					// "Access-Control-Request-Method" header. If it does, treat it as preflight request
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						// set necessary preflight headers
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Request-ID, traceparent, tracestate")
 						w.WriteHeader(http.StatusOK)
 						return
 					}
@@ -215,17 +363,47 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+// answer requests with 503 while the server is in maintenance mode, the
+// healthcheck, metrics and debug endpoints stay available for monitoring
+func (app *application) maintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.currentConfig().maintenanceMode {
+			switch {
+			case r.URL.Path == "/v1/healthcheck", r.URL.Path == "/metrics", strings.HasPrefix(r.URL.Path, "/debug/"):
+			default:
+				app.maintenanceModeResponse(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) metrics(next http.Handler) http.Handler {
-	totalRequestsReceived := expvar.NewInt("total_requests_received")
-	totalResponsesSent := expvar.NewInt("total_responses_sent")
-	totalProcessingTimeMicroseconds := expvar.NewInt("total_processing_time_microseconds")
-	totalResponseSentByStatus := expvar.NewMap("total_response_sent_by_status")
+	t := app.telemetry
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		totalRequestsReceived.Add(1)
+		t.inFlight.Inc()
+		defer t.inFlight.Dec()
+
+		r, info := app.contextWithRequestInfo(r)
 		metrics := httpsnoop.CaptureMetrics(next, w, r)
-		totalResponsesSent.Add(1)
-		totalProcessingTimeMicroseconds.Add(metrics.Duration.Microseconds())
-		totalResponseSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
+
+		// label by route pattern so that ids in urls do not create new series
+		pattern := info.routePattern()
+		method := metricsMethod(r.Method)
+		t.requests.WithLabelValues(method, pattern, strconv.Itoa(metrics.Code)).Inc()
+		t.requestDuration.WithLabelValues(method, pattern).Observe(metrics.Duration.Seconds())
 	})
 }
+
+// returns method if it is a standard HTTP method and "OTHER" otherwise,
+// so that clients can not create an unbounded number of series
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
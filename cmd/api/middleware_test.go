@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+)
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+	app, _ := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 1
+	app.config.limiter.burst = 2
+	ts := newTestServer(t, app)
+
+	wantStatuses := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i, want := range wantStatuses {
+		resp := ts.do(t, http.MethodGet, "/v1/healthcheck", "", nil)
+		if resp.status != want {
+			t.Errorf("request %d: got status %d; want %d", i+1, resp.status, want)
+		}
+	}
+}
+
+func TestAuthenticateHeader(t *testing.T) {
+	t.Parallel()
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{"Wrong scheme", "Basic abc"},
+		{"Malformed token", "Bearer abc"},
+		{"Unknown token", "Bearer ABCDEFGHIJKLMNOPQRSTUVWXYZ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/healthcheck", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", tt.header)
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusUnauthorized {
+				t.Errorf("got status %d; want %d", res.StatusCode, http.StatusUnauthorized)
+			}
+			if got := res.Header.Get("WWW-Authenticate"); got != "Bearer" {
+				t.Errorf("got WWW-Authenticate %q; want %q", got, "Bearer")
+			}
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	t.Parallel()
+	app, _ := newTestApplication(t)
+	app.config.cors.trustedOrigins = []string{"http://localhost:9000"}
+	ts := newTestServer(t, app)
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		wantStatus  int
+		wantOrigin  string
+		wantMethods string
+		wantHeaders string
+		wantExpose  string
+	}{
+		{"Trusted preflight", http.MethodOptions, "http://localhost:9000", http.StatusOK, "http://localhost:9000", "OPTIONS, PUT, PATCH, DELETE", "Authorization, Content-Type, X-Request-ID, traceparent, tracestate", "X-Request-ID"},
+		{"Untrusted preflight", http.MethodOptions, "http://evil.example.com", http.StatusOK, "", "", "", ""},
+		{"Trusted simple", http.MethodGet, "http://localhost:9000", http.StatusOK, "http://localhost:9000", "", "", "X-Request-ID"},
+		{"No origin", http.MethodGet, "", http.StatusOK, "", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+"/v1/healthcheck", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+			}
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d; want %d", res.StatusCode, tt.wantStatus)
+			}
+			if got := res.Header.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("got Access-Control-Allow-Origin %q; want %q", got, tt.wantOrigin)
+			}
+			if got := res.Header.Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Errorf("got Access-Control-Allow-Methods %q; want %q", got, tt.wantMethods)
+			}
+			if got := res.Header.Get("Access-Control-Allow-Headers"); got != tt.wantHeaders {
+				t.Errorf("got Access-Control-Allow-Headers %q; want %q", got, tt.wantHeaders)
+			}
+			if got := res.Header.Get("Access-Control-Expose-Headers"); got != tt.wantExpose {
+				t.Errorf("got Access-Control-Expose-Headers %q; want %q", got, tt.wantExpose)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"Generated", "", ""},
+		{"Accepted", "req-42:retry.1", "req-42:retry.1"},
+		{"Invalid characters", "has space", ""},
+		{"Too long", strings.Repeat("a", maxRequestIDLength+1), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/missing", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set("X-Request-ID", tt.header)
+			}
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			var body map[string]any
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+
+			got := res.Header.Get("X-Request-ID")
+			switch {
+			case tt.want != "" && got != tt.want:
+				t.Errorf("got X-Request-ID %q; want %q", got, tt.want)
+			case tt.want == "" && (len(got) != 32 || got == tt.header):
+				t.Errorf("got X-Request-ID %q; want a new id", got)
+			}
+			if body["request_id"] != got {
+				t.Errorf("got request_id %v in error envelope; want %q", body["request_id"], got)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	token := ts.createUser(t, m, "alice@example.com")
+	user, err := app.models.Users.GetByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	app.logger = jsonlog.New(&buf, jsonlog.LevelInfo)
+	resp := ts.do(t, http.MethodDelete, "/v1/tokens/sessions/999", token, nil)
+
+	var entry struct {
+		Message    string         `json:"message"`
+		Properties map[string]any `json:"properties"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding log entry %q: %v", buf.String(), err)
+	}
+	// numbers are decoded as float64
+	want := map[string]any{
+		"request_id": resp.headers.Get("X-Request-ID"),
+		"method":     http.MethodDelete,
+		"route":      "/v1/tokens/sessions/:id",
+		"status":     float64(resp.status),
+		"user_id":    float64(user.ID),
+		"ip":         "127.0.0.1",
+	}
+	for key, value := range want {
+		if got := entry.Properties[key]; got != value {
+			t.Errorf("got %s %v; want %v", key, got, value)
+		}
+	}
+	if bytes, ok := entry.Properties["bytes"].(float64); !ok || bytes <= 0 {
+		t.Errorf("got bytes %v; want a positive number", entry.Properties["bytes"])
+	}
+	if _, err := time.ParseDuration(fmt.Sprint(entry.Properties["duration"])); err != nil {
+		t.Errorf("got duration %v: %v", entry.Properties["duration"], err)
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	t.Parallel()
+	app, _ := newTestApplication(t)
+	var buf bytes.Buffer
+	app.logger = jsonlog.New(&buf, jsonlog.LevelInfo)
+
+	handler := app.requestID(app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusInternalServerError)
+	}
+	if got := rr.Header().Get("Connection"); got != "close" {
+		t.Errorf("got Connection %q; want close", got)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d log entries; want 1: %s", len(lines), buf.String())
+	}
+	var entry struct {
+		Level      string         `json:"level"`
+		Message    string         `json:"message"`
+		Properties map[string]any `json:"properties"`
+		Trace      string         `json:"trace"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Level != "ERROR" || entry.Message != "boom" {
+		t.Errorf("got %s entry %q; want ERROR entry %q", entry.Level, entry.Message, "boom")
+	}
+	if entry.Properties["request_id"] != rr.Header().Get("X-Request-ID") {
+		t.Errorf("got request_id %v; want %q", entry.Properties["request_id"], rr.Header().Get("X-Request-ID"))
+	}
+	if !strings.Contains(entry.Trace, "recoverPanic") {
+		t.Errorf("got trace %q; want the stack of the panic", entry.Trace)
+	}
+}
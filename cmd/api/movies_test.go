@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestMovieCRUD(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	token := ts.createUser(t, m, "writer@example.com", "movies:write")
+
+	// create
+	resp := ts.do(t, http.MethodPost, "/v1/movies", token, map[string]any{
+		"title":   "Moana",
+		"year":    2016,
+		"runtime": "107 mins",
+		"genres":  []string{"animation", "adventure"},
+	})
+	if resp.status != http.StatusCreated {
+		t.Fatalf("create: got status %d; body %v", resp.status, resp.body)
+	}
+	id := int(field(resp.body, "movie", "id").(float64))
+	if got, want := resp.headers.Get("Location"), fmt.Sprintf("/v1/movies/%d", id); got != want {
+		t.Errorf("got Location %q; want %q", got, want)
+	}
+	path := fmt.Sprintf("/v1/movies/%d", id)
+
+	// show
+	resp = ts.do(t, http.MethodGet, path, token, nil)
+	if resp.status != http.StatusOK {
+		t.Fatalf("show: got status %d; body %v", resp.status, resp.body)
+	}
+	if got := field(resp.body, "movie", "runtime"); got != "107 mins" {
+		t.Errorf("got runtime %v; want %q", got, "107 mins")
+	}
+
+	// update
+	resp = ts.do(t, http.MethodPatch, path, token, map[string]any{"title": "Moana 2"})
+	if resp.status != http.StatusCreated {
+		t.Fatalf("update: got status %d; body %v", resp.status, resp.body)
+	}
+	if got := field(resp.body, "movie", "title"); got != "Moana 2" {
+		t.Errorf("got title %v; want %q", got, "Moana 2")
+	}
+	if got := field(resp.body, "movie", "version"); got != float64(2) {
+		t.Errorf("got version %v; want 2", got)
+	}
+
+	// delete
+	resp = ts.do(t, http.MethodDelete, path, token, nil)
+	if resp.status != http.StatusOK {
+		t.Fatalf("delete: got status %d; body %v", resp.status, resp.body)
+	}
+	resp = ts.do(t, http.MethodGet, path, token, nil)
+	if resp.status != http.StatusNotFound {
+		t.Errorf("show deleted: got status %d; want %d", resp.status, http.StatusNotFound)
+	}
+	resp = ts.do(t, http.MethodDelete, path, token, nil)
+	if resp.status != http.StatusNotFound {
+		t.Errorf("delete deleted: got status %d; want %d", resp.status, http.StatusNotFound)
+	}
+}
+
+func TestCreateMovieValidation(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	token := ts.createUser(t, m, "writer@example.com", "movies:write")
+
+	tests := []struct {
+		name       string
+		body       any
+		wantStatus int
+	}{
+		{"Missing fields", map[string]any{"title": "Moana"}, http.StatusUnprocessableEntity},
+		{"Invalid runtime", map[string]any{"title": "Moana", "year": 2016, "runtime": "107", "genres": []string{"animation"}}, http.StatusBadRequest},
+		{"Unknown field", map[string]any{"rating": 5}, http.StatusBadRequest},
+		{"Duplicate genres", map[string]any{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"a", "a"}}, http.StatusUnprocessableEntity},
+		{"Empty body", nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.do(t, http.MethodPost, "/v1/movies", token, tt.body)
+			if resp.status != tt.wantStatus {
+				t.Errorf("got status %d; want %d; body %v", resp.status, tt.wantStatus, resp.body)
+			}
+		})
+	}
+}
+
+func TestListMovies(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	token := ts.createUser(t, m, "writer@example.com", "movies:write")
+
+	for _, movie := range []map[string]any{
+		{"title": "Black Panther", "year": 2018, "runtime": "134 mins", "genres": []string{"action", "adventure"}},
+		{"title": "Deadpool", "year": 2016, "runtime": "108 mins", "genres": []string{"action", "comedy"}},
+		{"title": "The Breakfast Club", "year": 1986, "runtime": "96 mins", "genres": []string{"drama"}},
+	} {
+		resp := ts.do(t, http.MethodPost, "/v1/movies", token, movie)
+		if resp.status != http.StatusCreated {
+			t.Fatalf("create: got status %d; body %v", resp.status, resp.body)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantTitles []string
+	}{
+		{"All", "", http.StatusOK, []string{"Black Panther", "Deadpool", "The Breakfast Club"}},
+		{"Title", "?title=panther", http.StatusOK, []string{"Black Panther"}},
+		{"Genres", "?genres=action,comedy", http.StatusOK, []string{"Deadpool"}},
+		{"Sort descending", "?sort=-year", http.StatusOK, []string{"Black Panther", "Deadpool", "The Breakfast Club"}},
+		{"Sort ascending", "?sort=runtime", http.StatusOK, []string{"The Breakfast Club", "Deadpool", "Black Panther"}},
+		{"Page size", "?page_size=2&page=2", http.StatusOK, []string{"The Breakfast Club"}},
+		{"Invalid sort", "?sort=rating", http.StatusUnprocessableEntity, nil},
+		{"Invalid page", "?page=0", http.StatusUnprocessableEntity, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.do(t, http.MethodGet, "/v1/movies"+tt.query, token, nil)
+			if resp.status != tt.wantStatus {
+				t.Fatalf("got status %d; want %d; body %v", resp.status, tt.wantStatus, resp.body)
+			}
+			if tt.wantTitles == nil {
+				return
+			}
+			movies, _ := resp.body["movies"].([]any)
+			var titles []string
+			for _, movie := range movies {
+				titles = append(titles, movie.(map[string]any)["title"].(string))
+			}
+			if fmt.Sprint(titles) != fmt.Sprint(tt.wantTitles) {
+				t.Errorf("got titles %v; want %v", titles, tt.wantTitles)
+			}
+		})
+	}
+}
+
+func TestMoviePermissions(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	viewer := ts.createUser(t, m, "viewer@example.com")
+	// registered but never activated
+	ts.registerUser(t, m, "Inactive", "inactive@example.com", "pa55word1234")
+	inactive := ts.authenticate(t, "inactive@example.com", "pa55word1234")
+
+	movie := map[string]any{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"}}
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       any
+		wantStatus int
+	}{
+		{"Anonymous list", http.MethodGet, "/v1/movies", "", nil, http.StatusUnauthorized},
+		{"Anonymous show", http.MethodGet, "/v1/movies/1", "", nil, http.StatusUnauthorized},
+		{"Inactive list", http.MethodGet, "/v1/movies", inactive, nil, http.StatusForbidden},
+		{"Viewer list", http.MethodGet, "/v1/movies", viewer, nil, http.StatusOK},
+		{"Viewer create", http.MethodPost, "/v1/movies", viewer, movie, http.StatusForbidden},
+		{"Viewer update", http.MethodPatch, "/v1/movies/1", viewer, movie, http.StatusForbidden},
+		{"Viewer delete", http.MethodDelete, "/v1/movies/1", viewer, nil, http.StatusForbidden},
+		{"Viewer show missing", http.MethodGet, "/v1/movies/1", viewer, nil, http.StatusNotFound},
+		{"Viewer show invalid id", http.MethodGet, "/v1/movies/abc", viewer, nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.do(t, tt.method, tt.path, tt.token, tt.body)
+			if resp.status != tt.wantStatus {
+				t.Errorf("got status %d; want %d; body %v", resp.status, tt.wantStatus, resp.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/anukuljoshi/greenlight/internal/data"
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+	"github.com/anukuljoshi/greenlight/internal/tracing"
+	"github.com/anukuljoshi/greenlight/internal/validator"
+)
+
+const (
+	// number of emails claimed from the outbox at once
+	outboxBatchSize = 10
+	// time a claimed email is hidden from other workers while being delivered
+	outboxLease = time.Minute
+	// upper bound for the delay between two delivery attempts
+	outboxMaxBackoff = time.Hour
+)
+
+// queue an email in the outbox, use models bound to a transaction to send the
+// email only if the transaction commits
+func queueEmail(ctx context.Context, models data.Models, recipient, templateFile string, values map[string]any) error {
+	email := &data.OutboxEmail{
+		Recipient: recipient,
+		Template:  templateFile,
+		Data:      values,
+	}
+	// continue the trace of the request when the email is delivered
+	if sc := tracing.SpanFromContext(ctx).SpanContext(); sc.IsValid() {
+		email.TraceParent = sc.Traceparent()
+	}
+	return models.Outbox.Insert(ctx, email)
+}
+
+// deliver due emails from the outbox until ctx is canceled
+func (app *application) runOutbox(ctx context.Context) {
+	ticker := time.NewTicker(app.config.outbox.interval)
+	defer ticker.Stop()
+	for {
+		err := app.processOutbox(ctx)
+		if err != nil && !errors.Is(err, data.ErrCanceled) {
+			app.logger.Error(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliver every email which is currently due
+// failed deliveries are retried with exponential backoff until the configured
+// number of attempts is reached, then the email is marked failed
+func (app *application) processOutbox(ctx context.Context) error {
+	for {
+		emails, err := app.models.Outbox.Claim(ctx, outboxBatchSize, outboxLease)
+		if err != nil {
+			return err
+		}
+		for _, email := range emails {
+			app.deliverEmail(ctx, email)
+		}
+		if len(emails) < outboxBatchSize {
+			return nil
+		}
+	}
+}
+
+func (app *application) deliverEmail(ctx context.Context, email *data.OutboxEmail) {
+	if sc, err := tracing.ParseTraceparent(email.TraceParent); err == nil {
+		ctx = tracing.ContextWithRemoteParent(ctx, sc)
+	}
+	_, span := app.tracer.Start(ctx, "mailer.Send",
+		tracing.WithKind(tracing.KindClient),
+		tracing.WithAttributes(
+			tracing.Int64("email.id", email.ID),
+			tracing.String("email.template", email.Template),
+			tracing.Int("email.attempts", email.Attempts),
+		),
+	)
+	sendErr := app.mailer.Send(email.Recipient, email.Template, email.Data)
+	span.RecordError(sendErr)
+	span.End()
+	// record the outcome even if ctx is canceled while the email was sent
+	ctx = context.WithoutCancel(ctx)
+	logger := app.logger.With(
+		jsonlog.Int64("email_id", email.ID),
+		jsonlog.Int("attempts", email.Attempts),
+	)
+
+	var err error
+	switch {
+	case sendErr == nil:
+		err = app.models.Outbox.MarkSent(ctx, email.ID)
+	case email.Attempts >= app.config.outbox.maxAttempts:
+		logger.Error(sendErr)
+		err = app.models.Outbox.MarkFailed(ctx, email.ID, sendErr.Error())
+	default:
+		logger.Error(sendErr)
+		next := time.Now().Add(outboxBackoff(app.config.outbox.backoff, email.Attempts))
+		err = app.models.Outbox.Reschedule(ctx, email.ID, sendErr.Error(), next)
+	}
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+// delay after the given number of failed attempts, doubled for every attempt
+func outboxBackoff(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < outboxMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, outboxMaxBackoff)
+}
+
+// list outbox emails, optionally filtered by status
+func (app *application) listOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Sort = app.readString(qs, "sort", "-id")
+	input.SortSafeList = []string{"id", "-id"}
+
+	v.Check(
+		validator.In(input.Status, "", data.OutboxPending, data.OutboxSent, data.OutboxFailed),
+		"status",
+		"must be pending, sent or failed",
+	)
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	emails, metadata, err := app.models.Outbox.GetAll(r.Context(), input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"emails": emails, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// queue a failed email for delivery again
+func (app *application) retryOutboxEmailHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Outbox.Retry(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "email queued for delivery"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{20, outboxMaxBackoff},
+	}
+	for _, tt := range tests {
+		if got := outboxBackoff(30*time.Second, tt.attempts); got != tt.want {
+			t.Errorf("attempts %d: got %s; want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	app.config.outbox.maxAttempts = 2
+	app.config.outbox.backoff = 0
+	ts := newTestServer(t, app)
+	admin := ts.createUser(t, m, "admin@example.com", "users:admin")
+	viewer := ts.createUser(t, m, "viewer@example.com")
+
+	// every delivery attempt fails until the email is marked failed
+	m.fail(errors.New("smtp unavailable"))
+	resp := ts.do(t, http.MethodPost, "/v1/users", "", map[string]string{
+		"name":     "Alice",
+		"email":    "alice@example.com",
+		"password": "pa55word1234",
+	})
+	if resp.status != http.StatusAccepted {
+		t.Fatalf("register: got status %d; body %v", resp.status, resp.body)
+	}
+	// the first attempt was made after the request, make the second one
+	err := app.processOutbox(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = ts.do(t, http.MethodGet, "/v1/admin/outbox?status=failed", admin, nil)
+	if resp.status != http.StatusOK {
+		t.Fatalf("list: got status %d; body %v", resp.status, resp.body)
+	}
+	emails, _ := resp.body["emails"].([]any)
+	if len(emails) != 1 {
+		t.Fatalf("got %d failed emails; want 1", len(emails))
+	}
+	email := emails[0].(map[string]any)
+	if email["recipient"] != "alice@example.com" || email["attempts"] != float64(2) || email["last_error"] != "smtp unavailable" {
+		t.Errorf("unexpected failed email %v", email)
+	}
+	if _, ok := email["data"]; ok {
+		t.Errorf("email exposes template data: %v", email)
+	}
+	if m.count("alice@example.com") != 0 {
+		t.Fatal("failed email was recorded as sent")
+	}
+	retryPath := fmt.Sprintf("/v1/admin/outbox/%d/retry", int(email["id"].(float64)))
+
+	m.fail(nil)
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{"Viewer list", http.MethodGet, "/v1/admin/outbox", viewer, http.StatusForbidden},
+		{"Viewer retry", http.MethodPost, retryPath, viewer, http.StatusForbidden},
+		{"Invalid status", http.MethodGet, "/v1/admin/outbox?status=lost", admin, http.StatusUnprocessableEntity},
+		{"Retry missing", http.MethodPost, "/v1/admin/outbox/999/retry", admin, http.StatusNotFound},
+		{"Retry", http.MethodPost, retryPath, admin, http.StatusAccepted},
+		{"Retry sent", http.MethodPost, retryPath, admin, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.do(t, tt.method, tt.path, tt.token, nil)
+			if resp.status != tt.wantStatus {
+				t.Errorf("got status %d; want %d; body %v", resp.status, tt.wantStatus, resp.body)
+			}
+		})
+	}
+
+	// the retried email was delivered and its token activates the account
+	token, _ := m.last(t, "alice@example.com", "user_welcome.tmpl.html").data["activationToken"].(string)
+	resp = ts.do(t, http.MethodPut, "/v1/users/activate", "", map[string]string{"token": token})
+	if resp.status != http.StatusOK {
+		t.Errorf("activate: got status %d; body %v", resp.status, resp.body)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestAdminPermissions(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := ts.createUser(t, m, "admin@example.com", "users:admin")
+	viewer := ts.createUser(t, m, "viewer@example.com")
+	user, err := app.models.Users.GetByEmail(context.Background(), "viewer@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	permissionsPath := fmt.Sprintf("/v1/admin/users/%d/permissions", user.ID)
+	rolesPath := fmt.Sprintf("/v1/admin/users/%d/roles", user.ID)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       any
+		wantStatus int
+	}{
+		{"Viewer list permissions", http.MethodGet, "/v1/permissions", viewer, nil, http.StatusForbidden},
+		{"Viewer grant", http.MethodPost, permissionsPath, viewer, map[string]any{"codes": []string{"users:admin"}}, http.StatusForbidden},
+		{"List permissions", http.MethodGet, "/v1/permissions", admin, nil, http.StatusOK},
+		{"List roles", http.MethodGet, "/v1/roles", admin, nil, http.StatusOK},
+		{"Show permissions", http.MethodGet, permissionsPath, admin, nil, http.StatusOK},
+		{"Show missing user", http.MethodGet, "/v1/admin/users/999/permissions", admin, nil, http.StatusNotFound},
+		{"Grant unknown code", http.MethodPost, permissionsPath, admin, map[string]any{"codes": []string{"movies:destroy"}}, http.StatusUnprocessableEntity},
+		{"Grant", http.MethodPost, permissionsPath, admin, map[string]any{"codes": []string{"movies:write"}}, http.StatusOK},
+		{"Viewer create movie", http.MethodPost, "/v1/movies", viewer, map[string]any{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"}}, http.StatusCreated},
+		{"Revoke", http.MethodDelete, permissionsPath + "/movies:write", admin, nil, http.StatusOK},
+		{"Viewer create movie after revoke", http.MethodPost, "/v1/movies", viewer, map[string]any{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"}}, http.StatusForbidden},
+		{"Show roles", http.MethodGet, rolesPath, admin, nil, http.StatusOK},
+		{"Grant unknown role", http.MethodPost, rolesPath, admin, map[string]any{"roles": []string{"owner"}}, http.StatusUnprocessableEntity},
+		{"Grant role", http.MethodPost, rolesPath, admin, map[string]any{"roles": []string{"editor"}}, http.StatusOK},
+		{"Editor delete missing movie", http.MethodDelete, "/v1/movies/999", viewer, nil, http.StatusNotFound},
+		{"Revoke role", http.MethodDelete, rolesPath + "/editor", admin, nil, http.StatusOK},
+		{"Viewer delete after role revoke", http.MethodDelete, "/v1/movies/999", viewer, nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.do(t, tt.method, tt.path, tt.token, tt.body)
+			if resp.status != tt.wantStatus {
+				t.Errorf("got status %d; want %d; body %v", resp.status, tt.wantStatus, resp.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+)
+
+// settings which are applied to the running server when it receives SIGHUP,
+// changes to any other setting need a restart
+var reloadableSettings = map[string]bool{
+	"limiter-rps":          true,
+	"limiter-burst":        true,
+	"limiter-enabled":      true,
+	"cors-trusted-origins": true,
+	"log-level":            true,
+	"maintenance-mode":     true,
+}
+
+// returns a copy of the config, reloadable settings must be read through it
+// while the server is running
+func (app *application) currentConfig() config {
+	app.configMu.RLock()
+	defer app.configMu.RUnlock()
+	return app.config
+}
+
+// reload reads the settings again and applies the reloadable ones which
+// changed, all at once. if the settings are invalid nothing is changed,
+// changes to other settings are logged and ignored
+func (app *application) reload() error {
+	s, err := app.reloadSettings()
+	if err != nil {
+		return err
+	}
+	next := s.config
+	err = next.validate()
+	if err != nil {
+		return err
+	}
+
+	current := app.currentConfig()
+	before, after := configValues(current), configValues(next)
+	var applied, rejected []string
+	for name, value := range after {
+		if before[name] == value {
+			continue
+		}
+		if reloadableSettings[name] {
+			applied = append(applied, name)
+		} else {
+			rejected = append(rejected, name)
+		}
+	}
+	sort.Strings(applied)
+	sort.Strings(rejected)
+	if len(rejected) > 0 {
+		app.logger.Warn("ignoring changed settings which require a restart", jsonlog.Any("settings", rejected))
+	}
+
+	app.configMu.Lock()
+	app.config.limiter = next.limiter
+	app.config.cors = next.cors
+	app.config.log.level = next.log.level
+	app.config.maintenanceMode = next.maintenanceMode
+	app.configMu.Unlock()
+	// the level may have been changed through the admin endpoint since, it
+	// is only overwritten if the configured level changed
+	if current.log.level != next.log.level {
+		app.logger.SetLevel(next.log.level)
+	}
+
+	app.logger.Info("configuration reloaded", jsonlog.Any("settings", applied))
+	return nil
+}
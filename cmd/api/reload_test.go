@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+)
+
+// returns a test application whose settings are read from a config file,
+// the returned function replaces the contents of the file
+func newReloadableApplication(t *testing.T, contents string) (*application, func(string), *bytes.Buffer) {
+	t.Helper()
+	app, _ := newTestApplication(t)
+	path := writeTempFile(t, "greenlight.conf", contents)
+	app.reloadSettings = func() (*settings, error) {
+		return loadSettings([]string{"-config", path}, env(nil))
+	}
+	s, err := app.reloadSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.config = s.config
+
+	var buf bytes.Buffer
+	app.logger = jsonlog.New(&buf, jsonlog.LevelInfo)
+	write := func(contents string) {
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return app, write, &buf
+}
+
+// returns the properties of the first log entry with message
+func logEntry(t *testing.T, buf *bytes.Buffer, message string) map[string]any {
+	t.Helper()
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry struct {
+			Message    string         `json:"message"`
+			Properties map[string]any `json:"properties"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err == nil && entry.Message == message {
+			return entry.Properties
+		}
+	}
+	t.Fatalf("no %q entry in log:\n%s", message, buf.String())
+	return nil
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+	app, write, buf := newReloadableApplication(t, `
+storage = memory
+mailer = log
+limiter-enabled = false
+`)
+	ts := newTestServer(t, app)
+
+	write(`
+storage = memory
+mailer = log
+port = 5000
+limiter-enabled = true
+limiter-rps = 1
+limiter-burst = 1
+cors-trusted-origins = http://localhost:9000
+log-level = debug
+maintenance-mode = true
+`)
+	if err := app.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := app.currentConfig()
+	if !cfg.limiter.enabled || cfg.limiter.rps != 1 || cfg.limiter.burst != 1 {
+		t.Errorf("got limiter %+v", cfg.limiter)
+	}
+	if cfg.port != 4000 {
+		t.Errorf("got port %d; want the port the server was started with", cfg.port)
+	}
+	if app.logger.Level() != jsonlog.LevelDebug {
+		t.Errorf("got log level %s; want DEBUG", app.logger.Level())
+	}
+	if got := logEntry(t, buf, "ignoring changed settings which require a restart")["settings"]; len(got.([]any)) != 1 || got.([]any)[0] != "port" {
+		t.Errorf("got rejected settings %v; want [port]", got)
+	}
+	applied := logEntry(t, buf, "configuration reloaded")["settings"].([]any)
+	if len(applied) != 6 {
+		t.Errorf("got applied settings %v", applied)
+	}
+
+	// the healthcheck reports maintenance mode, other requests are refused
+	// with the CORS headers of the new trusted origins
+	resp := ts.do(t, http.MethodGet, "/v1/healthcheck", "", nil)
+	if resp.status != http.StatusOK || field(resp.body, "status") != "maintenance" {
+		t.Errorf("got healthcheck status %d and body %v", resp.status, resp.body)
+	}
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/movies", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "http://localhost:9000")
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable || res.Header.Get("Retry-After") == "" {
+		t.Errorf("got status %d and Retry-After %q; want 503 with Retry-After", res.StatusCode, res.Header.Get("Retry-After"))
+	}
+	if got := res.Header.Get("Access-Control-Allow-Origin"); got != "http://localhost:9000" {
+		t.Errorf("got Access-Control-Allow-Origin %q", got)
+	}
+	// the limiter of the new settings applies, the healthcheck already used
+	// the only token
+	if resp := ts.do(t, http.MethodGet, "/v1/healthcheck", "", nil); resp.status != http.StatusTooManyRequests {
+		t.Errorf("got status %d; want %d", resp.status, http.StatusTooManyRequests)
+	}
+}
+
+func TestReloadLimiter(t *testing.T) {
+	t.Parallel()
+	app, write, _ := newReloadableApplication(t, `
+storage = memory
+mailer = log
+limiter-rps = 0.001
+limiter-burst = 5
+`)
+	ts := newTestServer(t, app)
+	ts.do(t, http.MethodGet, "/v1/healthcheck", "", nil)
+
+	// clients seen before the reload get the new burst
+	write(`
+storage = memory
+mailer = log
+limiter-rps = 0.001
+limiter-burst = 1
+`)
+	if err := app.reload(); err != nil {
+		t.Fatal(err)
+	}
+	wantStatuses := []int{http.StatusOK, http.StatusTooManyRequests}
+	for i, want := range wantStatuses {
+		if resp := ts.do(t, http.MethodGet, "/v1/healthcheck", "", nil); resp.status != want {
+			t.Errorf("request %d: got status %d; want %d", i+1, resp.status, want)
+		}
+	}
+}
+
+func TestReloadInvalid(t *testing.T) {
+	t.Parallel()
+	app, write, _ := newReloadableApplication(t, `
+storage = memory
+mailer = log
+limiter-rps = 2
+`)
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"Invalid setting", "storage = memory\nmailer = log\nlimiter-rps = -1\nmaintenance-mode = true\n"},
+		{"Unreadable file", "storage = memory\nmailer = log\nmaintenance-mode = true\nnope = 1\n"},
+	}
+	for _, tt := range tests {
+		write(tt.contents)
+		if err := app.reload(); err == nil {
+			t.Errorf("%s: got nil error", tt.name)
+		}
+		cfg := app.currentConfig()
+		if cfg.limiter.rps != 2 || cfg.maintenanceMode {
+			t.Errorf("%s: got limiter-rps %v and maintenance mode %v; want the settings unchanged", tt.name, cfg.limiter.rps, cfg.maintenanceMode)
+		}
+	}
+}
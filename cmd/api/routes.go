@@ -1,14 +1,13 @@
 package main
 
 import (
-	"expvar"
 	"net/http"
 
-	"github.com/julienschmidt/httprouter"
+	"github.com/anukuljoshi/greenlight/internal/tracing"
 )
 
 func (app *application) routes() http.Handler {
-	var router = httprouter.New()
+	var router = newRouter()
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
@@ -41,7 +40,104 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activate", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireActivatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireActivatedUser(app.updateCurrentUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireActivatedUser(app.deleteCurrentUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/email", app.requireActivatedUser(app.requestEmailChangeHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationHandler)
-	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requireAuthenticatedUser(app.deleteAllAuthenticationHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/tokens/sessions", app.requireAuthenticatedUser(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/sessions/:id", app.requireAuthenticatedUser(app.deleteSessionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	router.HandlerFunc(
+		http.MethodGet,
+		"/v1/permissions",
+		app.requirePermission("users:admin", app.listPermissionsHandler),
+	)
+	router.HandlerFunc(
+		http.MethodGet,
+		"/v1/admin/users/:id/permissions",
+		app.requirePermission("users:admin", app.showUserPermissionsHandler),
+	)
+	router.HandlerFunc(
+		http.MethodPost,
+		"/v1/admin/users/:id/permissions",
+		app.requirePermission("users:admin", app.grantUserPermissionsHandler),
+	)
+	router.HandlerFunc(
+		http.MethodDelete,
+		"/v1/admin/users/:id/permissions/:code",
+		app.requirePermission("users:admin", app.revokeUserPermissionHandler),
+	)
+	router.HandlerFunc(
+		http.MethodGet,
+		"/v1/roles",
+		app.requirePermission("users:admin", app.listRolesHandler),
+	)
+	router.HandlerFunc(
+		http.MethodGet,
+		"/v1/admin/users/:id/roles",
+		app.requirePermission("users:admin", app.showUserRolesHandler),
+	)
+	router.HandlerFunc(
+		http.MethodPost,
+		"/v1/admin/users/:id/roles",
+		app.requirePermission("users:admin", app.grantUserRolesHandler),
+	)
+	router.HandlerFunc(
+		http.MethodDelete,
+		"/v1/admin/users/:id/roles/:role",
+		app.requirePermission("users:admin", app.revokeUserRoleHandler),
+	)
+	router.HandlerFunc(
+		http.MethodGet,
+		"/v1/admin/outbox",
+		app.requirePermission("users:admin", app.listOutboxHandler),
+	)
+	router.HandlerFunc(
+		http.MethodPost,
+		"/v1/admin/outbox/:id/retry",
+		app.requirePermission("users:admin", app.retryOutboxEmailHandler),
+	)
+	router.HandlerFunc(
+		http.MethodGet,
+		"/v1/admin/log-level",
+		app.requirePermission("users:admin", app.showLogLevelHandler),
+	)
+	router.HandlerFunc(
+		http.MethodPut,
+		"/v1/admin/log-level",
+		app.requirePermission("users:admin", app.updateLogLevelHandler),
+	)
+
+	// metrics and debug endpoints move to their own listener when one is
+	// configured
+	if app.config.debug.addr == "" {
+		app.debugRoutes(router, func(next http.HandlerFunc) http.HandlerFunc {
+			return app.requirePermission("debug:read", next)
+		})
+	}
+	// middleware from the outermost to the innermost, the time spent in
+	// middleware after the request span is started is recorded in own spans
+	chain := []func(http.Handler) http.Handler{
+		app.requestID,
+		app.traceRequest,
+		app.logRequest,
+		app.metrics,
+		app.recoverPanic,
+		tracing.Middleware("enableCORS", app.enableCORS),
+		app.maintenance,
+		tracing.Middleware("rateLimit", app.rateLimit),
+		tracing.Middleware("authenticate", app.authenticate),
+	}
+	var handler http.Handler = router
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i](handler)
+	}
+	return handler
 }
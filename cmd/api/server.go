@@ -4,14 +4,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
 )
 
 func (app *application) serve() error {
+	// base context for all requests, canceled if graceful shutdown times out
+	// so that in-flight database queries are aborted
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
 	// create a server with config
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
@@ -19,7 +26,74 @@ func (app *application) serve() error {
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
+	}
+	// a separate server for /debug endpoints, pprof profiles take 30 seconds
+	// by default so there is no write timeout
+	var debugSrv *http.Server
+	if app.config.debug.addr != "" {
+		debugSrv = &http.Server{
+			Addr:        app.config.debug.addr,
+			Handler:     app.debugListenerRoutes(),
+			IdleTimeout: time.Minute,
+			ReadTimeout: 10 * time.Second,
+		}
+		go func() {
+			app.logger.Info("starting debug server", jsonlog.String("addr", debugSrv.Addr))
+			err := debugSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error(err, jsonlog.String("addr", debugSrv.Addr))
+			}
+		}()
+	}
+	// export spans until the workers are stopped, so their last spans are kept
+	tracingCtx, stopTracing := context.WithCancel(context.Background())
+	defer stopTracing()
+	tracingDone := make(chan struct{})
+	go func() {
+		defer close(tracingDone)
+		app.tracer.Run(tracingCtx)
+	}()
+	// start the outbox worker, the job queue and the maintenance scheduler,
+	// they are stopped before waiting for background tasks
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	app.wg.Add(3)
+	go func() {
+		defer app.wg.Done()
+		app.runOutbox(workersCtx)
+	}()
+	go func() {
+		defer app.wg.Done()
+		app.jobs.Run(workersCtx)
+	}()
+	go func() {
+		defer app.wg.Done()
+		app.runScheduler(workersCtx)
+	}()
+	// reload settings on SIGHUP until the server shuts down, the settings in
+	// effect are kept if the new ones can't be read or are invalid
+	if app.reloadSettings != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go func() {
+			for {
+				select {
+				case <-hup:
+					err := app.reload()
+					if err != nil {
+						app.logger.Error(fmt.Errorf("configuration not reloaded: %w", err))
+					}
+				case <-workersCtx.Done():
+					return
+				}
+			}
+		}()
 	}
+
 	shutdownError := make(chan error)
 	go func() {
 		// create a quit channel which carries os.Signal values
@@ -30,29 +104,35 @@ func (app *application) serve() error {
 		// this code will block until a signal is received
 		s := <-quit
 		// log a message when signal is caught
-		app.logger.PrintInfo("shutting down server", map[string]string{
-			"signal": s.String(),
-		})
+		app.logger.Info("shutting down server", jsonlog.String("signal", s.String()))
 		// create a context with 5 second timeout
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		// call shutdown passing the ctx and catching error
+		if debugSrv != nil {
+			// profiles which are still being recorded are cut short
+			debugSrv.Close()
+		}
 		err := srv.Shutdown(ctx)
 		if err != nil {
+			cancelBase()
 			shutdownError <- err
 		}
 		// log message indicating background task are being completed
-		app.logger.PrintInfo("completing background tasks", map[string]string{
-			"addr": srv.Addr,
-		})
+		app.logger.Info("completing background tasks", jsonlog.String("addr", srv.Addr))
+		// stop claiming emails and jobs, the ones already started are completed
+		stopWorkers()
 		// call wait on app.wg to wait for all goroutine to complete
 		app.wg.Wait()
+		// export spans which are still queued
+		stopTracing()
+		<-tracingDone
 		shutdownError <- nil
 	}()
-	app.logger.PrintInfo("starting server", map[string]string{
-		"addr": srv.Addr,
-		"env":  app.config.env,
-	})
+	app.logger.Info("starting server",
+		jsonlog.String("addr", srv.Addr),
+		jsonlog.String("env", app.config.env),
+	)
 	// calling shutdown return ErrServerClosed error
 	err := srv.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
@@ -64,8 +144,6 @@ func (app *application) serve() error {
 		return err
 	}
 	// graceful shutdown was successful
-	app.logger.PrintInfo("stopped server", map[string]string{
-		"addr": srv.Addr,
-	})
+	app.logger.Info("stopped server", jsonlog.String("addr", srv.Addr))
 	return nil
 }
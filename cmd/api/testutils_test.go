@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anukuljoshi/greenlight/internal/data"
+	"github.com/anukuljoshi/greenlight/internal/jobs"
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+	"github.com/anukuljoshi/greenlight/internal/tracing"
+)
+
+// email captured by testMailer instead of being sent
+type sentEmail struct {
+	recipient    string
+	templateFile string
+	data         map[string]any
+}
+
+// mailer which records every email so tests can read tokens from them
+type testMailer struct {
+	mu   sync.Mutex
+	sent []sentEmail
+	err  error
+}
+
+func (m *testMailer) Send(recipient, templateFile string, data any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	values, _ := data.(map[string]any)
+	m.sent = append(m.sent, sentEmail{
+		recipient:    recipient,
+		templateFile: templateFile,
+		data:         values,
+	})
+	return nil
+}
+
+// make every following Send fail with err, or succeed again if err is nil
+func (m *testMailer) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+// returns the most recent email sent to recipient with templateFile
+func (m *testMailer) last(t *testing.T, recipient, templateFile string) sentEmail {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].recipient == recipient && m.sent[i].templateFile == templateFile {
+			return m.sent[i]
+		}
+	}
+	t.Fatalf("no %q email sent to %s", templateFile, recipient)
+	return sentEmail{}
+}
+
+// returns the number of emails sent to recipient
+func (m *testMailer) count(recipient string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, email := range m.sent {
+		if email.recipient == recipient {
+			n++
+		}
+	}
+	return n
+}
+
+// returns an application backed by in-memory models and a capturing mailer
+func newTestApplication(t *testing.T) (*application, *testMailer) {
+	t.Helper()
+	var cfg config
+	cfg.env = "development"
+	cfg.limiter.enabled = false
+	cfg.users.defaultRole = "viewer"
+	cfg.outbox.maxAttempts = 5
+	cfg.outbox.backoff = time.Minute
+
+	m := &testMailer{}
+	logger := jsonlog.New(io.Discard, jsonlog.LevelOff)
+	models := data.NewMemoryModels()
+	telemetry := newTelemetry()
+	app := &application{
+		config:    cfg,
+		logger:    logger,
+		models:    models,
+		mailer:    countingMailer{mailSender: m, emails: telemetry.emails},
+		jobs:      jobs.New(models.Jobs, logger, jobs.Config{MaxAttempts: 1}),
+		telemetry: telemetry,
+		tracer:    tracing.New(nil, logger, tracing.Config{}),
+	}
+	app.registerMaintenanceJobs()
+	return app, m
+}
+
+type testServer struct {
+	*httptest.Server
+	app *application
+}
+
+// starts a test server for the application routes, closed when the test ends
+func newTestServer(t *testing.T, app *application) *testServer {
+	t.Helper()
+	ts := httptest.NewServer(app.routes())
+	t.Cleanup(ts.Close)
+	return &testServer{Server: ts, app: app}
+}
+
+// response returned from testServer.do with the decoded JSON body
+type testResponse struct {
+	status  int
+	headers http.Header
+	body    map[string]any
+}
+
+// send a request with an optional JSON body and bearer token
+// background tasks started by the request are completed, due jobs are run and
+// due emails in the outbox are delivered before returning
+func (ts *testServer) do(t *testing.T, method, path, token string, body any) testResponse {
+	t.Helper()
+	var reqBody io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqBody = bytes.NewReader(js)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	ts.app.wg.Wait()
+	err = ts.app.jobs.RunDue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ts.app.processOutbox(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := testResponse{status: res.StatusCode, headers: res.Header}
+	js, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(js) > 0 {
+		err = json.Unmarshal(js, &resp.body)
+		if err != nil {
+			t.Fatalf("decoding response body %q: %v", js, err)
+		}
+	}
+	return resp
+}
+
+// send a GET request with an optional bearer token and return the response
+// with its body, which is not decoded
+func (ts *testServer) get(t *testing.T, path, token string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(body)
+}
+
+// returns the string at a path of nested object keys in a decoded JSON body
+func field(body map[string]any, keys ...string) any {
+	var v any = body
+	for _, key := range keys {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = obj[key]
+	}
+	return v
+}
+
+// register a user and return the activation token from the welcome email
+func (ts *testServer) registerUser(t *testing.T, m *testMailer, name, email, password string) string {
+	t.Helper()
+	resp := ts.do(t, http.MethodPost, "/v1/users", "", map[string]string{
+		"name":     name,
+		"email":    email,
+		"password": password,
+	})
+	if resp.status != http.StatusAccepted {
+		t.Fatalf("register user: got status %d; body %v", resp.status, resp.body)
+	}
+	token, _ := m.last(t, email, "user_welcome.tmpl.html").data["activationToken"].(string)
+	return token
+}
+
+// register and activate a user, grant extra permissions and return an authentication token
+func (ts *testServer) createUser(t *testing.T, m *testMailer, email string, permissions ...string) string {
+	t.Helper()
+	password := "pa55word1234"
+	activationToken := ts.registerUser(t, m, "Test User", email, password)
+	resp := ts.do(t, http.MethodPut, "/v1/users/activate", "", map[string]string{"token": activationToken})
+	if resp.status != http.StatusOK {
+		t.Fatalf("activate user: got status %d; body %v", resp.status, resp.body)
+	}
+	if len(permissions) > 0 {
+		user, err := ts.app.models.Users.GetByEmail(context.Background(), email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ts.app.models.Permissions.AddForUser(context.Background(), user.ID, permissions...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ts.authenticate(t, email, password)
+}
+
+// create an authentication token for email and password
+func (ts *testServer) authenticate(t *testing.T, email, password string) string {
+	t.Helper()
+	resp := ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]string{
+		"email":    email,
+		"password": password,
+	})
+	if resp.status != http.StatusCreated {
+		t.Fatalf("authenticate: got status %d; body %v", resp.status, resp.body)
+	}
+	token, _ := field(resp.body, "token", "token").(string)
+	return token
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestCreateAuthentication(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	ts.registerUser(t, m, "Alice", "alice@example.com", "pa55word1234")
+
+	tests := []struct {
+		name       string
+		email      string
+		password   string
+		wantStatus int
+	}{
+		{"Valid", "alice@example.com", "pa55word1234", http.StatusCreated},
+		{"Wrong password", "alice@example.com", "wrongpassword", http.StatusUnauthorized},
+		{"Unknown email", "bob@example.com", "pa55word1234", http.StatusUnauthorized},
+		{"Invalid email", "alice", "pa55word1234", http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]string{
+				"email":    tt.email,
+				"password": tt.password,
+			})
+			if resp.status != tt.wantStatus {
+				t.Fatalf("got status %d; want %d; body %v", resp.status, tt.wantStatus, resp.body)
+			}
+			if resp.status == http.StatusCreated && field(resp.body, "token", "token") == nil {
+				t.Errorf("missing token in %v", resp.body)
+			}
+		})
+	}
+}
+
+func TestResendActivation(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	firstToken := ts.registerUser(t, m, "Alice", "alice@example.com", "pa55word1234")
+	ts.createUser(t, m, "bob@example.com")
+
+	// unknown and activated emails get the same response but no email
+	for _, email := range []string{"nobody@example.com", "bob@example.com"} {
+		before := m.count(email)
+		resp := ts.do(t, http.MethodPost, "/v1/tokens/activation", "", map[string]string{"email": email})
+		if resp.status != http.StatusAccepted {
+			t.Errorf("%s: got status %d; want %d", email, resp.status, http.StatusAccepted)
+		}
+		if m.count(email) != before {
+			t.Errorf("%s: unexpected email sent", email)
+		}
+	}
+
+	resp := ts.do(t, http.MethodPost, "/v1/tokens/activation", "", map[string]string{"email": "alice@example.com"})
+	if resp.status != http.StatusAccepted {
+		t.Fatalf("got status %d; body %v", resp.status, resp.body)
+	}
+	newToken, _ := m.last(t, "alice@example.com", "token_activation.tmpl.html").data["activationToken"].(string)
+
+	// previous activation token is invalidated
+	resp = ts.do(t, http.MethodPut, "/v1/users/activate", "", map[string]string{"token": firstToken})
+	if resp.status != http.StatusUnprocessableEntity {
+		t.Errorf("old token: got status %d; want %d", resp.status, http.StatusUnprocessableEntity)
+	}
+	resp = ts.do(t, http.MethodPut, "/v1/users/activate", "", map[string]string{"token": newToken})
+	if resp.status != http.StatusOK {
+		t.Errorf("new token: got status %d; want %d", resp.status, http.StatusOK)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	first := ts.createUser(t, m, "alice@example.com")
+	second := ts.authenticate(t, "alice@example.com", "pa55word1234")
+	third := ts.authenticate(t, "alice@example.com", "pa55word1234")
+
+	resp := ts.do(t, http.MethodDelete, "/v1/tokens/authentication", "", nil)
+	if resp.status != http.StatusUnauthorized {
+		t.Errorf("anonymous: got status %d; want %d", resp.status, http.StatusUnauthorized)
+	}
+
+	resp = ts.do(t, http.MethodDelete, "/v1/tokens/authentication", first, nil)
+	if resp.status != http.StatusOK {
+		t.Fatalf("logout: got status %d; body %v", resp.status, resp.body)
+	}
+	if resp := ts.do(t, http.MethodGet, "/v1/users/me", first, nil); resp.status != http.StatusUnauthorized {
+		t.Errorf("revoked token: got status %d; want %d", resp.status, http.StatusUnauthorized)
+	}
+	if resp := ts.do(t, http.MethodGet, "/v1/users/me", second, nil); resp.status != http.StatusOK {
+		t.Errorf("other token: got status %d; want %d", resp.status, http.StatusOK)
+	}
+
+	resp = ts.do(t, http.MethodDelete, "/v1/tokens/authentication/all", second, nil)
+	if resp.status != http.StatusOK {
+		t.Fatalf("logout all: got status %d; body %v", resp.status, resp.body)
+	}
+	for _, token := range []string{second, third} {
+		if resp := ts.do(t, http.MethodGet, "/v1/users/me", token, nil); resp.status != http.StatusUnauthorized {
+			t.Errorf("revoked token: got status %d; want %d", resp.status, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestSessions(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	current := ts.createUser(t, m, "alice@example.com")
+	other := ts.authenticate(t, "alice@example.com", "pa55word1234")
+	bob := ts.createUser(t, m, "bob@example.com")
+
+	resp := ts.do(t, http.MethodGet, "/v1/tokens/sessions", current, nil)
+	if resp.status != http.StatusOK {
+		t.Fatalf("list: got status %d; body %v", resp.status, resp.body)
+	}
+	sessions, _ := resp.body["sessions"].([]any)
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions; want 2", len(sessions))
+	}
+	var otherID float64
+	for _, s := range sessions {
+		session := s.(map[string]any)
+		if _, ok := session["hash"]; ok {
+			t.Errorf("session exposes token hash: %v", session)
+		}
+		if session["current"] != true {
+			otherID = session["id"].(float64)
+		}
+	}
+	path := fmt.Sprintf("/v1/tokens/sessions/%d", int(otherID))
+
+	// sessions of other users can not be revoked
+	if resp := ts.do(t, http.MethodDelete, path, bob, nil); resp.status != http.StatusNotFound {
+		t.Errorf("other user: got status %d; want %d", resp.status, http.StatusNotFound)
+	}
+	if resp := ts.do(t, http.MethodDelete, path, current, nil); resp.status != http.StatusOK {
+		t.Fatalf("revoke: got status %d; body %v", resp.status, resp.body)
+	}
+	if resp := ts.do(t, http.MethodGet, "/v1/users/me", other, nil); resp.status != http.StatusUnauthorized {
+		t.Errorf("revoked session: got status %d; want %d", resp.status, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/anukuljoshi/greenlight/internal/tracing"
+)
+
+// exporter which keeps every exported span
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) Export(ctx context.Context, spans []tracing.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestTraceRequest(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	ts.createUser(t, m, "alice@example.com")
+
+	rec := &spanRecorder{}
+	app.tracer = tracing.New(rec, app.logger, tracing.Config{SampleRatio: 1})
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/v1/tokens/password-reset", strings.NewReader(`{"email": "alice@example.com"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("got status %d; want %d", res.StatusCode, http.StatusAccepted)
+	}
+	if err := app.processOutbox(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stopped, stop := context.WithCancel(context.Background())
+	stop()
+	app.tracer.Run(stopped)
+
+	spans := make(map[string]tracing.SpanData)
+	for _, span := range rec.spans {
+		spans[span.Name] = span
+	}
+	server, found := spans["POST /v1/tokens/password-reset"]
+	if !found {
+		t.Fatalf("no span for the request in %v", rec.spans)
+	}
+	if got := server.ParentSpanID.String(); got != "00f067aa0ba902b7" {
+		t.Errorf("got request parent %s; want the span of the traceparent header", got)
+	}
+	for _, name := range []string{"enableCORS", "rateLimit", "authenticate", "mailer.Send"} {
+		span, found := spans[name]
+		if !found {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if got := span.SpanContext.TraceID.String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("%s: got trace id %s", name, got)
+		}
+		if span.ParentSpanID != server.SpanContext.SpanID {
+			t.Errorf("%s: got parent %s; want the request span %s", name, span.ParentSpanID, server.SpanContext.SpanID)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anukuljoshi/greenlight/internal/data"
+)
+
+func TestRegisterUser(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	ts.registerUser(t, m, "Alice", "alice@example.com", "pa55word1234")
+
+	tests := []struct {
+		name       string
+		body       map[string]string
+		wantStatus int
+		wantError  string
+	}{
+		{"Duplicate email", map[string]string{"name": "Alice", "email": "alice@example.com", "password": "pa55word1234"}, http.StatusUnprocessableEntity, "email"},
+		{"Invalid email", map[string]string{"name": "Bob", "email": "bob", "password": "pa55word1234"}, http.StatusUnprocessableEntity, "email"},
+		{"Short password", map[string]string{"name": "Bob", "email": "bob@example.com", "password": "pa55"}, http.StatusUnprocessableEntity, "password"},
+		{"Missing name", map[string]string{"email": "bob@example.com", "password": "pa55word1234"}, http.StatusUnprocessableEntity, "name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.do(t, http.MethodPost, "/v1/users", "", tt.body)
+			if resp.status != tt.wantStatus {
+				t.Fatalf("got status %d; want %d; body %v", resp.status, tt.wantStatus, resp.body)
+			}
+			if field(resp.body, "error", tt.wantError) == nil {
+				t.Errorf("missing %q error in %v", tt.wantError, resp.body)
+			}
+		})
+	}
+}
+
+func TestCheckRole(t *testing.T) {
+	t.Parallel()
+	models := data.NewMemoryModels()
+	if err := checkRole(models, "viewer"); err != nil {
+		t.Errorf("got error %v for an existing role", err)
+	}
+	if err := checkRole(models, "owner"); err == nil {
+		t.Error("got nil error for an unknown role")
+	}
+}
+
+// token model which fails to create new tokens while fail is set
+type failingTokenModel struct {
+	data.TokenModelInterface
+	fail *atomic.Bool
+}
+
+func (m failingTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*data.Token, error) {
+	if m.fail.Load() {
+		return nil, errors.New("token insert failed")
+	}
+	return m.TokenModelInterface.New(ctx, userID, ttl, scope)
+}
+
+func TestRegisterUserRollback(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	var fail atomic.Bool
+	fail.Store(true)
+	// the failing model is also used by the models of transactions
+	app.models = data.NewWrappedMemoryModels(func(models data.Models) data.Models {
+		models.Tokens = failingTokenModel{models.Tokens, &fail}
+		return models
+	})
+	ts := newTestServer(t, app)
+	body := map[string]string{"name": "Alice", "email": "alice@example.com", "password": "pa55word1234"}
+
+	resp := ts.do(t, http.MethodPost, "/v1/users", "", body)
+	if resp.status != http.StatusInternalServerError {
+		t.Fatalf("got status %d; want %d", resp.status, http.StatusInternalServerError)
+	}
+	// the user inserted before the token failed was rolled back
+	_, err := app.models.Users.GetByEmail(context.Background(), "alice@example.com")
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+	if m.count("alice@example.com") != 0 {
+		t.Error("welcome email sent for rolled back user")
+	}
+
+	// the same email can register once the failure is gone
+	fail.Store(false)
+	ts.registerUser(t, m, "Alice", "alice@example.com", "pa55word1234")
+}
+
+func TestActivateUser(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	token := ts.registerUser(t, m, "Alice", "alice@example.com", "pa55word1234")
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"Malformed token", "abc", http.StatusUnprocessableEntity},
+		{"Unknown token", "ABCDEFGHIJKLMNOPQRSTUVWXYZ", http.StatusUnprocessableEntity},
+		{"Valid token", token, http.StatusOK},
+		{"Used token", token, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.do(t, http.MethodPut, "/v1/users/activate", "", map[string]string{"token": tt.token})
+			if resp.status != tt.wantStatus {
+				t.Fatalf("got status %d; want %d; body %v", resp.status, tt.wantStatus, resp.body)
+			}
+			if resp.status == http.StatusOK && field(resp.body, "user", "activated") != true {
+				t.Errorf("user not activated: %v", resp.body)
+			}
+		})
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	oldToken := ts.createUser(t, m, "alice@example.com")
+
+	resp := ts.do(t, http.MethodPost, "/v1/tokens/password-reset", "", map[string]string{"email": "nobody@example.com"})
+	if resp.status != http.StatusUnprocessableEntity {
+		t.Errorf("unknown email: got status %d; want %d", resp.status, http.StatusUnprocessableEntity)
+	}
+
+	resp = ts.do(t, http.MethodPost, "/v1/tokens/password-reset", "", map[string]string{"email": "alice@example.com"})
+	if resp.status != http.StatusAccepted {
+		t.Fatalf("request reset: got status %d; body %v", resp.status, resp.body)
+	}
+	resetToken, _ := m.last(t, "alice@example.com", "token_password_reset.tmpl.html").data["passwordResetToken"].(string)
+
+	resp = ts.do(t, http.MethodPut, "/v1/users/password", "", map[string]string{"password": "newpa55word", "token": resetToken})
+	if resp.status != http.StatusOK {
+		t.Fatalf("reset password: got status %d; body %v", resp.status, resp.body)
+	}
+
+	// reset token is single use and existing sessions are revoked
+	resp = ts.do(t, http.MethodPut, "/v1/users/password", "", map[string]string{"password": "newpa55word", "token": resetToken})
+	if resp.status != http.StatusUnprocessableEntity {
+		t.Errorf("reused token: got status %d; want %d", resp.status, http.StatusUnprocessableEntity)
+	}
+	resp = ts.do(t, http.MethodGet, "/v1/users/me", oldToken, nil)
+	if resp.status != http.StatusUnauthorized {
+		t.Errorf("old session: got status %d; want %d", resp.status, http.StatusUnauthorized)
+	}
+	ts.authenticate(t, "alice@example.com", "newpa55word")
+}
+
+func TestCurrentUser(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	token := ts.createUser(t, m, "alice@example.com")
+	otherToken := ts.authenticate(t, "alice@example.com", "pa55word1234")
+
+	resp := ts.do(t, http.MethodGet, "/v1/users/me", "", nil)
+	if resp.status != http.StatusUnauthorized {
+		t.Errorf("anonymous: got status %d; want %d", resp.status, http.StatusUnauthorized)
+	}
+
+	resp = ts.do(t, http.MethodGet, "/v1/users/me", token, nil)
+	if resp.status != http.StatusOK {
+		t.Fatalf("show: got status %d; body %v", resp.status, resp.body)
+	}
+	if got := field(resp.body, "user", "email"); got != "alice@example.com" {
+		t.Errorf("got email %v; want %q", got, "alice@example.com")
+	}
+
+	tests := []struct {
+		name       string
+		body       map[string]string
+		wantStatus int
+	}{
+		{"Rename", map[string]string{"name": "Alice Smith"}, http.StatusOK},
+		{"Empty name", map[string]string{"name": ""}, http.StatusUnprocessableEntity},
+		{"Password without current", map[string]string{"password": "newpa55word"}, http.StatusUnprocessableEntity},
+		{"Password with wrong current", map[string]string{"password": "newpa55word", "current_password": "wrongpassword"}, http.StatusUnprocessableEntity},
+		{"Password with current", map[string]string{"password": "newpa55word", "current_password": "pa55word1234"}, http.StatusOK},
+	}
+	resp = ts.do(t, http.MethodPost, "/v1/tokens/password-reset", "", map[string]string{"email": "alice@example.com"})
+	if resp.status != http.StatusAccepted {
+		t.Fatalf("request reset: got status %d; body %v", resp.status, resp.body)
+	}
+	resetToken, _ := m.last(t, "alice@example.com", "token_password_reset.tmpl.html").data["passwordResetToken"].(string)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.do(t, http.MethodPatch, "/v1/users/me", token, tt.body)
+			if resp.status != tt.wantStatus {
+				t.Errorf("got status %d; want %d; body %v", resp.status, tt.wantStatus, resp.body)
+			}
+		})
+	}
+
+	// changing the password revokes every session and password reset token
+	for _, old := range []string{token, otherToken} {
+		if resp := ts.do(t, http.MethodGet, "/v1/users/me", old, nil); resp.status != http.StatusUnauthorized {
+			t.Errorf("old session: got status %d; want %d", resp.status, http.StatusUnauthorized)
+		}
+	}
+	resp = ts.do(t, http.MethodPut, "/v1/users/password", "", map[string]string{"password": "otherpa55word", "token": resetToken})
+	if resp.status != http.StatusUnprocessableEntity {
+		t.Errorf("old reset token: got status %d; want %d", resp.status, http.StatusUnprocessableEntity)
+	}
+	token = ts.authenticate(t, "alice@example.com", "newpa55word")
+
+	resp = ts.do(t, http.MethodDelete, "/v1/users/me", token, nil)
+	if resp.status != http.StatusOK {
+		t.Fatalf("delete: got status %d; body %v", resp.status, resp.body)
+	}
+	resp = ts.do(t, http.MethodGet, "/v1/users/me", token, nil)
+	if resp.status != http.StatusUnauthorized {
+		t.Errorf("deleted user: got status %d; want %d", resp.status, http.StatusUnauthorized)
+	}
+}
+
+func TestEmailChange(t *testing.T) {
+	t.Parallel()
+	app, m := newTestApplication(t)
+	ts := newTestServer(t, app)
+	token := ts.createUser(t, m, "alice@example.com")
+	ts.registerUser(t, m, "Bob", "bob@example.com", "pa55word1234")
+
+	tests := []struct {
+		name       string
+		body       map[string]string
+		wantStatus int
+	}{
+		{"Wrong password", map[string]string{"email": "new@example.com", "password": "wrongpassword"}, http.StatusUnauthorized},
+		{"Same email", map[string]string{"email": "alice@example.com", "password": "pa55word1234"}, http.StatusUnprocessableEntity},
+		{"Taken email", map[string]string{"email": "bob@example.com", "password": "pa55word1234"}, http.StatusUnprocessableEntity},
+		{"Valid", map[string]string{"email": "new@example.com", "password": "pa55word1234"}, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.do(t, http.MethodPost, "/v1/users/me/email", token, tt.body)
+			if resp.status != tt.wantStatus {
+				t.Errorf("got status %d; want %d; body %v", resp.status, tt.wantStatus, resp.body)
+			}
+		})
+	}
+
+	// a notice is sent to the old address and a token to the new one
+	m.last(t, "alice@example.com", "user_email_change_notice.tmpl.html")
+	changeToken, _ := m.last(t, "new@example.com", "token_email_change.tmpl.html").data["emailChangeToken"].(string)
+
+	resp := ts.do(t, http.MethodPut, "/v1/users/email", "", map[string]string{"token": changeToken})
+	if resp.status != http.StatusOK {
+		t.Fatalf("confirm: got status %d; body %v", resp.status, resp.body)
+	}
+	if got := field(resp.body, "user", "email"); got != "new@example.com" {
+		t.Errorf("got email %v; want %q", got, "new@example.com")
+	}
+	resp = ts.do(t, http.MethodPut, "/v1/users/email", "", map[string]string{"token": changeToken})
+	if resp.status != http.StatusUnprocessableEntity {
+		t.Errorf("reused token: got status %d; want %d", resp.status, http.StatusUnprocessableEntity)
+	}
+	ts.authenticate(t, "new@example.com", "pa55word1234")
+}
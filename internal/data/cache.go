@@ -0,0 +1,235 @@
+package data
+
+import (
+	"crypto/sha256"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache holds in-process, TTL bounded copies of user permissions and
+// authentication token lookups. A nil *Cache is valid and caches nothing.
+type Cache struct {
+	permissions *ttlCache[int64, Permissions]
+	tokens      *ttlCache[[sha256.Size]byte, User]
+	// set for models bound to a transaction
+	tx *cacheTx
+}
+
+// return a new cache, entries are kept for at most ttl
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		permissions: newTTLCache[int64, Permissions](ttl),
+		tokens:      newTTLCache[[sha256.Size]byte, User](ttl),
+	}
+}
+
+// returns hit and miss counters for each cache
+func (c *Cache) Stats() map[string]int64 {
+	if c == nil {
+		return nil
+	}
+	return map[string]int64{
+		"permissions_hits":   c.permissions.hits.Load(),
+		"permissions_misses": c.permissions.misses.Load(),
+		"tokens_hits":        c.tokens.hits.Load(),
+		"tokens_misses":      c.tokens.misses.Load(),
+	}
+}
+
+// returns the cache to use for models bound to a transaction and a function
+// which must be called once the transaction has ended
+// invalidations made in the transaction are repeated by end, as a concurrent
+// lookup may cache a row which the transaction has changed but not committed
+func (c *Cache) beginTx() (tx *Cache, end func()) {
+	if c == nil || c.tx != nil {
+		return c, func() {}
+	}
+	t := &cacheTx{}
+	return &Cache{permissions: c.permissions, tokens: c.tokens, tx: t}, t.end
+}
+
+// the generation is returned on a miss and must be passed to the matching set
+func (c *Cache) getPermissions(userID int64) (Permissions, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+	return c.permissions.get(userID)
+}
+
+func (c *Cache) setPermissions(userID int64, permissions Permissions, gen uint64) {
+	// a transaction may read rows it has not committed yet
+	if c == nil || c.tx != nil {
+		return
+	}
+	c.permissions.set(userID, permissions, time.Time{}, gen)
+}
+
+// remove cached permissions for a user
+func (c *Cache) invalidatePermissions(userID int64) {
+	if c == nil {
+		return
+	}
+	c.invalidate(func() {
+		c.permissions.delete(userID)
+	})
+}
+
+// returns a copy of the cached user for an authentication token hash
+func (c *Cache) getTokenUser(hash [sha256.Size]byte) (*User, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+	user, gen, ok := c.tokens.get(hash)
+	if !ok {
+		return nil, gen, false
+	}
+	return &user, gen, true
+}
+
+// cache a copy of the user for a token hash, never past the token expiry
+func (c *Cache) setTokenUser(hash [sha256.Size]byte, user *User, expiry time.Time, gen uint64) {
+	if c == nil || c.tx != nil {
+		return
+	}
+	c.tokens.set(hash, *user, expiry, gen)
+}
+
+// remove the cached user for a single token hash
+func (c *Cache) invalidateToken(hash [sha256.Size]byte) {
+	if c == nil {
+		return
+	}
+	c.invalidate(func() {
+		c.tokens.delete(hash)
+	})
+}
+
+// remove all cached token lookups which resolve to a user
+func (c *Cache) invalidateUserTokens(userID int64) {
+	if c == nil {
+		return
+	}
+	c.invalidate(func() {
+		c.tokens.deleteFunc(func(user User) bool {
+			return user.ID == userID
+		})
+	})
+}
+
+// run fn now and again when the transaction ends, if there is one
+func (c *Cache) invalidate(fn func()) {
+	fn()
+	if c.tx != nil {
+		c.tx.add(fn)
+	}
+}
+
+// invalidations made by models bound to a transaction
+type cacheTx struct {
+	mu      sync.Mutex
+	pending []func()
+}
+
+func (t *cacheTx) add(fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, fn)
+}
+
+func (t *cacheTx) end() {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	for _, fn := range pending {
+		fn()
+	}
+}
+
+type ttlItem[V any] struct {
+	value  V
+	expiry time.Time
+}
+
+// thread-safe map with per entry expiry
+type ttlCache[K comparable, V any] struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	items     map[K]ttlItem[V]
+	lastPrune time.Time
+	// incremented by every delete, a value read before a delete is stale
+	gen    uint64
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func newTTLCache[K comparable, V any](ttl time.Duration) *ttlCache[K, V] {
+	return &ttlCache[K, V]{
+		ttl:       ttl,
+		items:     make(map[K]ttlItem[V]),
+		lastPrune: time.Now(),
+	}
+}
+
+// returns the value for key and the current generation
+func (c *ttlCache[K, V]) get(key K) (V, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, found := c.items[key]
+	if !found || time.Now().After(item.expiry) {
+		delete(c.items, key)
+		c.misses.Add(1)
+		var zero V
+		return zero, c.gen, false
+	}
+	c.hits.Add(1)
+	return item.value, c.gen, true
+}
+
+// store value for key, expiry caps the cache ttl when it is not zero
+// the value is dropped if anything was deleted since get returned gen, as it
+// may have been read from the database before the change that caused the delete
+func (c *ttlCache[K, V]) set(key K, value V, expiry time.Time, gen uint64) {
+	if c.ttl <= 0 {
+		return
+	}
+	exp := time.Now().Add(c.ttl)
+	if !expiry.IsZero() && expiry.Before(exp) {
+		exp = expiry
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	// drop expired entries once per ttl so the map does not grow without bound
+	now := time.Now()
+	if now.Sub(c.lastPrune) > c.ttl {
+		for k, item := range c.items {
+			if now.After(item.expiry) {
+				delete(c.items, k)
+			}
+		}
+		c.lastPrune = now
+	}
+	c.items[key] = ttlItem[V]{value: value, expiry: exp}
+}
+
+func (c *ttlCache[K, V]) delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	delete(c.items, key)
+}
+
+func (c *ttlCache[K, V]) deleteFunc(match func(V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for k, item := range c.items {
+		if match(item.value) {
+			delete(c.items, k)
+		}
+	}
+}
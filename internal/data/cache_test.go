@@ -0,0 +1,73 @@
+package data
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+func TestCacheStaleFill(t *testing.T) {
+	cache := NewCache(time.Minute)
+
+	// a lookup misses, then the permissions change before it fills the cache
+	_, gen, ok := cache.getPermissions(1)
+	if ok {
+		t.Fatal("got hit on empty cache")
+	}
+	cache.invalidatePermissions(1)
+	cache.setPermissions(1, Permissions{"movies:read"}, gen)
+	if _, _, ok := cache.getPermissions(1); ok {
+		t.Error("stale permissions were cached")
+	}
+
+	_, gen, _ = cache.getPermissions(1)
+	cache.setPermissions(1, Permissions{"movies:read"}, gen)
+	if _, _, ok := cache.getPermissions(1); !ok {
+		t.Error("permissions were not cached")
+	}
+}
+
+func TestCacheTx(t *testing.T) {
+	cache := NewCache(time.Minute)
+	hash := sha256.Sum256([]byte("token"))
+	user := &User{ID: 1}
+
+	tx, end := cache.beginTx()
+	tx.invalidateUserTokens(user.ID)
+
+	// a concurrent lookup fills the cache with the row the transaction
+	// has not committed yet
+	_, gen, _ := cache.getTokenUser(hash)
+	cache.setTokenUser(hash, user, time.Time{}, gen)
+	if _, _, ok := cache.getTokenUser(hash); !ok {
+		t.Fatal("user was not cached")
+	}
+
+	// values read in the transaction may be uncommitted
+	_, gen, _ = tx.getPermissions(user.ID)
+	tx.setPermissions(user.ID, Permissions{"movies:write"}, gen)
+	if _, _, ok := cache.getPermissions(user.ID); ok {
+		t.Error("permissions read in a transaction were cached")
+	}
+
+	end()
+	if _, _, ok := cache.getTokenUser(hash); ok {
+		t.Error("token lookup was not invalidated when the transaction ended")
+	}
+
+	// nested transactions share the outer one
+	tx, end = cache.beginTx()
+	nested, nestedEnd := tx.beginTx()
+	if nested != tx {
+		t.Error("nested transaction got a new cache")
+	}
+	nestedEnd()
+	end()
+
+	var none *Cache
+	tx, end = none.beginTx()
+	if tx != nil {
+		t.Error("nil cache returned a cache for a transaction")
+	}
+	end()
+}
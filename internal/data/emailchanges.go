@@ -0,0 +1,99 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// EmailChangeModel stores pending email addresses alongside an email-change token
+type EmailChangeModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// creates a new email-change token and stores the pending email address with it
+func (m EmailChangeModel) New(ctx context.Context, userID int64, ttl time.Duration, newEmail string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := queryContext(ctx, m.Timeout, "EmailChangeModel.New")
+	defer cancel()
+
+	// insert token and pending email in a single transaction
+	err = inTx(ctx, m.DB, func(tx DBTX) error {
+		query := `
+			INSERT INTO tokens (hash, user_id, expiry, scope)
+			VALUES ($1, $2, $3, $4);
+		`
+		_, err := tx.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		query = `
+			INSERT INTO email_changes (token_hash, new_email)
+			VALUES ($1, $2);
+		`
+		_, err = tx.ExecContext(ctx, query, token.Hash, newEmail)
+		return queryError(ctx, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// get user and pending email address related to an email-change token
+func (m EmailChangeModel) GetForToken(ctx context.Context, tokenPlaintext string) (*User, string, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, email_changes.new_email
+		FROM users
+		INNER JOIN tokens
+		ON users.id = tokens.user_id
+		INNER JOIN email_changes
+		ON tokens.hash = email_changes.token_hash
+		WHERE tokens.hash = $1
+		AND tokens.scope = $2
+		AND tokens.expiry > $3;
+	`
+	args := []any{
+		tokenHash[:],
+		ScopeEmailChange,
+		time.Now(),
+	}
+	var user User
+	var newEmail string
+
+	ctx, cancel := queryContext(ctx, m.Timeout, "EmailChangeModel.GetForToken")
+	defer cancel()
+
+	err := m.DB.QueryRowContext(
+		ctx,
+		query,
+		args...,
+	).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&newEmail,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, "", ErrRecordNotFound
+		default:
+			return nil, "", queryError(ctx, err)
+		}
+	}
+	return &user, newEmail, nil
+}
@@ -0,0 +1,187 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	JobPending = "pending"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a unit of background work run by a handler registered for its kind
+type Job struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Kind       string          `json:"kind"`
+	Payload    json.RawMessage `json:"payload"`
+	Status     string          `json:"status"`
+	Attempts   int             `json:"attempts"`
+	RunAt      time.Time       `json:"run_at"`
+	LastError  string          `json:"last_error,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+type JobModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+const jobColumns = `id, created_at, kind, payload, status, attempts, run_at, last_error, finished_at`
+
+// queue a job, the job runs immediately if RunAt is not set
+func (m JobModel) Insert(ctx context.Context, job *Job) error {
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+	if job.Payload == nil {
+		job.Payload = json.RawMessage("{}")
+	}
+	query := `
+		INSERT INTO jobs (kind, payload, run_at)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, status;
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout, "JobModel.Insert")
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, job.Kind, []byte(job.Payload), job.RunAt).Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.Status,
+	)
+	return queryError(ctx, err)
+}
+
+// scan a row selected with jobColumns
+func scanJob(row interface{ Scan(...any) error }, job *Job) error {
+	var payload []byte
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.Kind,
+		&payload,
+		&job.Status,
+		&job.Attempts,
+		&job.RunAt,
+		&job.LastError,
+		&job.FinishedAt,
+	)
+	job.Payload = payload
+	return err
+}
+
+// retrieve a job with id
+func (m JobModel) Get(ctx context.Context, id int64) (*Job, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT ` + jobColumns + `
+		FROM jobs
+		WHERE id = $1;
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout, "JobModel.Get")
+	defer cancel()
+
+	var job Job
+	err := scanJob(m.DB.QueryRowContext(ctx, query, id), &job)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &job, nil
+}
+
+// claim up to limit due jobs and count the attempt
+// claimed jobs are not due again until lease has passed, so a job is retried
+// if the worker stops before marking it done or failed
+// rows locked by another worker are skipped
+func (m JobModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]*Job, error) {
+	query := `
+		UPDATE jobs
+		SET attempts = attempts + 1, run_at = $3
+		WHERE id IN (
+			SELECT id
+			FROM jobs
+			WHERE status = $1
+			AND run_at <= NOW()
+			ORDER BY run_at, id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + jobColumns + `;
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout, "JobModel.Claim")
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, JobPending, limit, time.Now().Add(lease))
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		var job Job
+		err := scanJob(rows, &job)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		jobs = append(jobs, &job)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return jobs, nil
+}
+
+// mark a job as completed
+func (m JobModel) MarkDone(ctx context.Context, id int64) error {
+	query := `
+		UPDATE jobs
+		SET status = $1, finished_at = NOW(), last_error = ''
+		WHERE id = $2;
+	`
+	return m.exec(ctx, "JobModel.MarkDone", query, JobDone, id)
+}
+
+// mark a job as failed, it is not run again
+func (m JobModel) MarkFailed(ctx context.Context, id int64, lastError string) error {
+	query := `
+		UPDATE jobs
+		SET status = $1, finished_at = NOW(), last_error = $2
+		WHERE id = $3;
+	`
+	return m.exec(ctx, "JobModel.MarkFailed", query, JobFailed, lastError, id)
+}
+
+// record a failed attempt and schedule the next one
+func (m JobModel) Reschedule(ctx context.Context, id int64, lastError string, runAt time.Time) error {
+	query := `
+		UPDATE jobs
+		SET last_error = $1, run_at = $2
+		WHERE id = $3;
+	`
+	return m.exec(ctx, "JobModel.Reschedule", query, lastError, runAt, id)
+}
+
+func (m JobModel) exec(ctx context.Context, name, query string, args ...any) error {
+	ctx, cancel := queryContext(ctx, m.Timeout, name)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return queryError(ctx, err)
+}
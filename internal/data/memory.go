@@ -0,0 +1,1119 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// memoryStore holds all records for the in-memory models. Every model shares
+// one store so that relations between users, tokens and permissions behave
+// like the PostgreSQL schema, including ON DELETE CASCADE.
+type memoryStore struct {
+	// guards the tables, the models of a transaction share the tables with a
+	// no-op lock as the transaction holds the lock until it ends
+	mu sync.Locker
+	*memoryTables
+
+	// applied to every Models value built on the store
+	wrap func(m Models) Models
+}
+
+type memoryTables struct {
+	movies      map[int64]Movie
+	lastMovieID int64
+
+	users      map[int64]User
+	lastUserID int64
+
+	tokens      map[[sha256.Size]byte]memoryToken
+	lastTokenID int64
+
+	permissions     []string
+	userPermissions map[int64]map[string]bool
+
+	roles     map[string]Permissions
+	userRoles map[int64]map[string]bool
+
+	emailChanges map[[sha256.Size]byte]string
+
+	outbox       map[int64]memoryOutboxEmail
+	lastOutboxID int64
+
+	jobs      map[int64]Job
+	lastJobID int64
+}
+
+// lock of the models of a transaction, which already holds the store lock
+type txLocker struct{}
+
+func (txLocker) Lock()   {}
+func (txLocker) Unlock() {}
+
+// token row with session metadata
+type memoryToken struct {
+	Token
+	ID         int64
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// outbox row with template data stored as JSON like the jsonb column
+type memoryOutboxEmail struct {
+	OutboxEmail
+	data []byte
+}
+
+// returns models backed by a thread-safe in-memory store
+// the store is seeded with the same permissions and roles as the migrations
+func NewMemoryModels() Models {
+	return NewWrappedMemoryModels(nil)
+}
+
+// NewWrappedMemoryModels returns in-memory models which are passed through
+// wrap, including the models of a transaction, so that tests can replace a
+// model with one that fails
+func NewWrappedMemoryModels(wrap func(m Models) Models) Models {
+	tables := &memoryTables{
+		movies:          make(map[int64]Movie),
+		users:           make(map[int64]User),
+		tokens:          make(map[[sha256.Size]byte]memoryToken),
+		permissions:     []string{"movies:read", "movies:write", "users:admin", "debug:read"},
+		userPermissions: make(map[int64]map[string]bool),
+		roles: map[string]Permissions{
+			"viewer": {"movies:read"},
+			"editor": {"movies:read", "movies:write"},
+			"admin":  {"movies:read", "movies:write", "users:admin", "debug:read"},
+		},
+		userRoles:    make(map[int64]map[string]bool),
+		emailChanges: make(map[[sha256.Size]byte]string),
+		outbox:       make(map[int64]memoryOutboxEmail),
+		jobs:         make(map[int64]Job),
+	}
+	store := &memoryStore{mu: &sync.Mutex{}, memoryTables: tables, wrap: wrap}
+	models := store.models()
+	// a transaction holds the store lock until it ends, so other callers
+	// neither see its writes before it commits nor write while it runs, and
+	// restoring the snapshot taken at its start only undoes its own writes
+	// fn must only use the models it is passed, other models of the store
+	// block until the transaction ends
+	models.withTx = func(ctx context.Context, fn func(tx Models) error) error {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+
+		snapshot := tables.clone()
+		tx := &memoryStore{mu: txLocker{}, memoryTables: tables, wrap: wrap}
+		err := fn(tx.models())
+		if err != nil {
+			*tables = *snapshot
+		}
+		return err
+	}
+	return models
+}
+
+// returns models sharing the store, passed through s.wrap if set
+func (s *memoryStore) models() Models {
+	models := Models{
+		Movies:       MemoryMovieModel{store: s},
+		Users:        MemoryUserModel{store: s},
+		Tokens:       MemoryTokenModel{store: s},
+		Permissions:  MemoryPermissionModel{store: s},
+		Roles:        MemoryRoleModel{store: s},
+		EmailChanges: MemoryEmailChangeModel{store: s},
+		Outbox:       MemoryOutboxModel{store: s},
+		Jobs:         MemoryJobModel{store: s},
+	}
+	if s.wrap != nil {
+		models = s.wrap(models)
+	}
+	return models
+}
+
+// copy every table, the caller must hold the store lock
+func (s *memoryTables) clone() *memoryTables {
+	c := &memoryTables{
+		movies:          maps.Clone(s.movies),
+		lastMovieID:     s.lastMovieID,
+		users:           maps.Clone(s.users),
+		lastUserID:      s.lastUserID,
+		tokens:          maps.Clone(s.tokens),
+		lastTokenID:     s.lastTokenID,
+		permissions:     s.permissions,
+		userPermissions: make(map[int64]map[string]bool, len(s.userPermissions)),
+		roles:           s.roles,
+		userRoles:       make(map[int64]map[string]bool, len(s.userRoles)),
+		emailChanges:    maps.Clone(s.emailChanges),
+		outbox:          maps.Clone(s.outbox),
+		lastOutboxID:    s.lastOutboxID,
+		jobs:            maps.Clone(s.jobs),
+		lastJobID:       s.lastJobID,
+	}
+	for id, codes := range s.userPermissions {
+		c.userPermissions[id] = maps.Clone(codes)
+	}
+	for id, names := range s.userRoles {
+		c.userRoles[id] = maps.Clone(names)
+	}
+	return c
+}
+
+// return ErrCanceled if ctx is already done, mirroring a canceled query
+func checkContext(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ErrCanceled
+	}
+	return nil
+}
+
+// copy a movie so callers can not modify stored records
+func copyMovie(movie Movie) *Movie {
+	movie.Genres = append([]string(nil), movie.Genres...)
+	return &movie
+}
+
+// delete a user and every row which references it
+func (s *memoryStore) deleteUser(id int64) {
+	delete(s.users, id)
+	delete(s.userPermissions, id)
+	delete(s.userRoles, id)
+	for hash, token := range s.tokens {
+		if token.UserID == id {
+			s.deleteToken(hash)
+		}
+	}
+}
+
+// delete a token and its pending email change
+func (s *memoryStore) deleteToken(hash [sha256.Size]byte) {
+	delete(s.tokens, hash)
+	delete(s.emailChanges, hash)
+}
+
+// check if an email is used by any user other than exceptID, emails are case insensitive
+func (s *memoryStore) emailTaken(email string, exceptID int64) bool {
+	for id, user := range s.users {
+		if id != exceptID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the user for an unexpired token with scope
+func (s *memoryStore) userForToken(scope, tokenPlaintext string) (*User, [sha256.Size]byte, bool) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	token, found := s.tokens[hash]
+	if !found || token.Scope != scope || !token.Expiry.After(time.Now()) {
+		return nil, hash, false
+	}
+	user, found := s.users[token.UserID]
+	if !found {
+		return nil, hash, false
+	}
+	return &user, hash, true
+}
+
+type MemoryMovieModel struct {
+	store *memoryStore
+}
+
+func (m MemoryMovieModel) Insert(ctx context.Context, movie *Movie) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.lastMovieID++
+	movie.ID = m.store.lastMovieID
+	movie.CreatedAt = time.Now()
+	movie.Version = 1
+	m.store.movies[movie.ID] = *copyMovie(*movie)
+	return nil
+}
+
+func (m MemoryMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	movie, found := m.store.movies[id]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+	return copyMovie(movie), nil
+}
+
+// filters by title words and genres like the full-text query in MovieModel.GetAll
+func (m MemoryMovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, Metadata{}, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	titleWords := splitWords(title)
+	var matched []*Movie
+	for _, movie := range m.store.movies {
+		if !containsAll(splitWords(movie.Title), titleWords) || !containsAll(movie.Genres, genres) {
+			continue
+		}
+		matched = append(matched, copyMovie(movie))
+	}
+
+	column, desc := filters.SortColumn(), filters.SortDirection() == "DESC"
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		var cmp int
+		switch column {
+		case "title":
+			cmp = strings.Compare(a.Title, b.Title)
+		case "year":
+			cmp = compareInt(int64(a.Year), int64(b.Year))
+		case "runtime":
+			cmp = compareInt(int64(a.Runtime), int64(b.Runtime))
+		default:
+			cmp = compareInt(a.ID, b.ID)
+		}
+		if desc {
+			cmp = -cmp
+		}
+		if cmp == 0 {
+			return a.ID < b.ID
+		}
+		return cmp < 0
+	})
+
+	totalRecords := len(matched)
+	start := min(filters.GetOffset(), totalRecords)
+	end := min(start+filters.GetLimit(), totalRecords)
+	movies := append([]*Movie{}, matched[start:end]...)
+
+	metadata := Metadata{}
+	if len(movies) > 0 {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+	return movies, metadata, nil
+}
+
+func (m MemoryMovieModel) Update(ctx context.Context, movie *Movie) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	stored, found := m.store.movies[movie.ID]
+	if !found || stored.Version != movie.Version {
+		return ErrEditConflict
+	}
+	movie.Version++
+	m.store.movies[movie.ID] = *copyMovie(*movie)
+	return nil
+}
+
+func (m MemoryMovieModel) Delete(ctx context.Context, id int64) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, found := m.store.movies[id]; !found {
+		return ErrRecordNotFound
+	}
+	delete(m.store.movies, id)
+	return nil
+}
+
+type MemoryUserModel struct {
+	store *memoryStore
+}
+
+func (m MemoryUserModel) Insert(ctx context.Context, user *User) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.store.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+	m.store.lastUserID++
+	user.ID = m.store.lastUserID
+	user.CreatedAt = time.Now()
+	user.Version = 1
+	m.store.users[user.ID] = *user
+	return nil
+}
+
+func (m MemoryUserModel) Get(ctx context.Context, id int64) (*User, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	user, found := m.store.users[id]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+	return &user, nil
+}
+
+func (m MemoryUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, user := range m.store.users {
+		if strings.EqualFold(user.Email, email) {
+			return &user, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+func (m MemoryUserModel) Update(ctx context.Context, user *User) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.store.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+	stored, found := m.store.users[user.ID]
+	if !found || stored.Version != user.Version {
+		return ErrEditConflict
+	}
+	user.Version++
+	m.store.users[user.ID] = *user
+	return nil
+}
+
+func (m MemoryUserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	user, _, found := m.store.userForToken(tokenScope, tokenPlaintext)
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+	return user, nil
+}
+
+func (m MemoryUserModel) Delete(ctx context.Context, id int64) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, found := m.store.users[id]; !found {
+		return ErrRecordNotFound
+	}
+	m.store.deleteUser(id)
+	return nil
+}
+
+func (m MemoryUserModel) DeleteUnactivated(ctx context.Context, createdBefore time.Time) (int64, error) {
+	if err := checkContext(ctx); err != nil {
+		return 0, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	var deleted int64
+	for id, user := range m.store.users {
+		if !user.Activated && user.CreatedAt.Before(createdBefore) {
+			m.store.deleteUser(id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+type MemoryTokenModel struct {
+	store *memoryStore
+}
+
+func (m MemoryTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m MemoryTokenModel) NewSession(ctx context.Context, userID int64, ttl time.Duration, ip, userAgent string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+	token.IP = ip
+	token.UserAgent = userAgent
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m MemoryTokenModel) Insert(ctx context.Context, token *Token) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+	return m.store.insertToken(token)
+}
+
+// insert a token, the user must exist like the tokens.user_id foreign key
+func (s *memoryStore) insertToken(token *Token) error {
+	if _, found := s.users[token.UserID]; !found {
+		return ErrRecordNotFound
+	}
+	s.lastTokenID++
+	s.tokens[[sha256.Size]byte(token.Hash)] = memoryToken{
+		Token:     *token,
+		ID:        s.lastTokenID,
+		CreatedAt: time.Now(),
+	}
+	return nil
+}
+
+func (m MemoryTokenModel) DeleteForToken(ctx context.Context, scope, tokenPlaintext string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	token, found := m.store.tokens[hash]
+	if !found || token.Scope != scope {
+		return ErrRecordNotFound
+	}
+	m.store.deleteToken(hash)
+	return nil
+}
+
+func (m MemoryTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for hash, token := range m.store.tokens {
+		if token.Scope == scope && token.UserID == userID {
+			m.store.deleteToken(hash)
+		}
+	}
+	return nil
+}
+
+func (m MemoryTokenModel) Touch(ctx context.Context, scope, tokenPlaintext string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	if token, found := m.store.tokens[hash]; found && token.Scope == scope {
+		now := time.Now()
+		token.LastUsedAt = &now
+		m.store.tokens[hash] = token
+	}
+	return nil
+}
+
+func (m MemoryTokenModel) GetAllSessionsForUser(ctx context.Context, userID int64) ([]*Session, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	var sessions = []*Session{}
+	now := time.Now()
+	for _, token := range m.store.tokens {
+		if token.Scope != ScopeAuthentication || token.UserID != userID || !token.Expiry.After(now) {
+			continue
+		}
+		sessions = append(sessions, &Session{
+			ID:         token.ID,
+			CreatedAt:  token.CreatedAt,
+			LastUsedAt: token.LastUsedAt,
+			Expiry:     token.Expiry,
+			IP:         token.IP,
+			UserAgent:  token.UserAgent,
+			Hash:       token.Hash,
+		})
+	}
+	// newest first, like the ORDER BY in TokenModel.GetAllSessionsForUser
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ID > sessions[j].ID
+	})
+	return sessions, nil
+}
+
+func (m MemoryTokenModel) DeleteSessionForUser(ctx context.Context, id, userID int64) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for hash, token := range m.store.tokens {
+		if token.ID == id && token.Scope == ScopeAuthentication && token.UserID == userID {
+			m.store.deleteToken(hash)
+			return nil
+		}
+	}
+	return ErrRecordNotFound
+}
+
+func (m MemoryTokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	if err := checkContext(ctx); err != nil {
+		return 0, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	now := time.Now()
+	var deleted int64
+	for hash, token := range m.store.tokens {
+		if token.Expiry.Before(now) {
+			m.store.deleteToken(hash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+type MemoryPermissionModel struct {
+	store *memoryStore
+}
+
+func (m MemoryPermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	permissions := append(Permissions{}, m.store.permissions...)
+	sort.Strings(permissions)
+	return permissions, nil
+}
+
+// returns the union of direct and role-derived permissions
+func (m MemoryPermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	codes := make(map[string]bool)
+	for code := range m.store.userPermissions[userID] {
+		codes[code] = true
+	}
+	for role := range m.store.userRoles[userID] {
+		for _, code := range m.store.roles[role] {
+			codes[code] = true
+		}
+	}
+	var permissions Permissions
+	for code := range codes {
+		permissions = append(permissions, code)
+	}
+	sort.Strings(permissions)
+	return permissions, nil
+}
+
+func (m MemoryPermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, found := m.store.users[userID]; !found {
+		return ErrRecordNotFound
+	}
+	for _, code := range codes {
+		// unknown codes are ignored like the INSERT ... SELECT in PermissionModel
+		if !Permissions(m.store.permissions).Include(code) {
+			continue
+		}
+		if m.store.userPermissions[userID] == nil {
+			m.store.userPermissions[userID] = make(map[string]bool)
+		}
+		m.store.userPermissions[userID][code] = true
+	}
+	return nil
+}
+
+func (m MemoryPermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, code := range codes {
+		delete(m.store.userPermissions[userID], code)
+	}
+	return nil
+}
+
+type MemoryRoleModel struct {
+	store *memoryStore
+}
+
+func (m MemoryRoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	var roles = []*Role{}
+	for name, permissions := range m.store.roles {
+		roles = append(roles, &Role{Name: name, Permissions: append(Permissions{}, permissions...)})
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Name < roles[j].Name
+	})
+	return roles, nil
+}
+
+func (m MemoryRoleModel) GetAllForUser(ctx context.Context, userID int64) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	var roles = []string{}
+	for role := range m.store.userRoles[userID] {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles, nil
+}
+
+func (m MemoryRoleModel) AddForUser(ctx context.Context, userID int64, names ...string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, found := m.store.users[userID]; !found {
+		return ErrRecordNotFound
+	}
+	for _, name := range names {
+		// unknown roles are ignored like the INSERT ... SELECT in RoleModel
+		if _, found := m.store.roles[name]; !found {
+			continue
+		}
+		if m.store.userRoles[userID] == nil {
+			m.store.userRoles[userID] = make(map[string]bool)
+		}
+		m.store.userRoles[userID][name] = true
+	}
+	return nil
+}
+
+func (m MemoryRoleModel) RemoveForUser(ctx context.Context, userID int64, names ...string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, name := range names {
+		delete(m.store.userRoles[userID], name)
+	}
+	return nil
+}
+
+type MemoryEmailChangeModel struct {
+	store *memoryStore
+}
+
+func (m MemoryEmailChangeModel) New(ctx context.Context, userID int64, ttl time.Duration, newEmail string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	err = m.store.insertToken(token)
+	if err != nil {
+		return nil, err
+	}
+	m.store.emailChanges[[sha256.Size]byte(token.Hash)] = newEmail
+	return token, nil
+}
+
+func (m MemoryEmailChangeModel) GetForToken(ctx context.Context, tokenPlaintext string) (*User, string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, "", err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	user, hash, found := m.store.userForToken(ScopeEmailChange, tokenPlaintext)
+	if !found {
+		return nil, "", ErrRecordNotFound
+	}
+	newEmail, found := m.store.emailChanges[hash]
+	if !found {
+		return nil, "", ErrRecordNotFound
+	}
+	return user, newEmail, nil
+}
+
+type MemoryOutboxModel struct {
+	store *memoryStore
+}
+
+func (m MemoryOutboxModel) Insert(ctx context.Context, email *OutboxEmail) error {
+	js, err := json.Marshal(email.Data)
+	if err != nil {
+		return err
+	}
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.lastOutboxID++
+	email.ID = m.store.lastOutboxID
+	email.CreatedAt = time.Now()
+	email.Status = OutboxPending
+	email.NextAttemptAt = email.CreatedAt
+	stored := memoryOutboxEmail{OutboxEmail: *email, data: js}
+	stored.Data = nil
+	m.store.outbox[email.ID] = stored
+	return nil
+}
+
+func (m MemoryOutboxModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEmail, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	now := time.Now()
+	var due []memoryOutboxEmail
+	for _, email := range m.store.outbox {
+		if email.Status == OutboxPending && !email.NextAttemptAt.After(now) {
+			due = append(due, email)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].ID < due[j].ID
+		}
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+
+	emails := []*OutboxEmail{}
+	for _, email := range due[:min(limit, len(due))] {
+		email.Attempts++
+		email.NextAttemptAt = now.Add(lease)
+		m.store.outbox[email.ID] = email
+		claimed, err := email.decode()
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, claimed)
+	}
+	return emails, nil
+}
+
+func (m MemoryOutboxModel) MarkSent(ctx context.Context, id int64) error {
+	return m.update(ctx, id, func(email *memoryOutboxEmail) {
+		now := time.Now()
+		email.Status = OutboxSent
+		email.SentAt = &now
+		email.LastError = ""
+		email.data = []byte("{}")
+	})
+}
+
+func (m MemoryOutboxModel) MarkFailed(ctx context.Context, id int64, lastError string) error {
+	return m.update(ctx, id, func(email *memoryOutboxEmail) {
+		email.Status = OutboxFailed
+		email.LastError = lastError
+	})
+}
+
+func (m MemoryOutboxModel) Reschedule(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error {
+	return m.update(ctx, id, func(email *memoryOutboxEmail) {
+		email.LastError = lastError
+		email.NextAttemptAt = nextAttemptAt
+	})
+}
+
+func (m MemoryOutboxModel) Retry(ctx context.Context, id int64) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	email, found := m.store.outbox[id]
+	if !found || email.Status != OutboxFailed {
+		return ErrRecordNotFound
+	}
+	email.Status = OutboxPending
+	email.Attempts = 0
+	email.NextAttemptAt = time.Now()
+	m.store.outbox[id] = email
+	return nil
+}
+
+func (m MemoryOutboxModel) GetAll(ctx context.Context, status string, filters Filters) ([]*OutboxEmail, Metadata, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, Metadata{}, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	var matched []*OutboxEmail
+	for _, email := range m.store.outbox {
+		if status != "" && email.Status != status {
+			continue
+		}
+		decoded, err := email.decode()
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		matched = append(matched, decoded)
+	}
+
+	desc := filters.SortDirection() == "DESC"
+	sort.Slice(matched, func(i, j int) bool {
+		if desc {
+			return matched[i].ID > matched[j].ID
+		}
+		return matched[i].ID < matched[j].ID
+	})
+
+	totalRecords := len(matched)
+	start := min(filters.GetOffset(), totalRecords)
+	end := min(start+filters.GetLimit(), totalRecords)
+	emails := append([]*OutboxEmail{}, matched[start:end]...)
+
+	metadata := Metadata{}
+	if len(emails) > 0 {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+	return emails, metadata, nil
+}
+
+// apply fn to the stored email with id, missing emails are ignored like an UPDATE
+func (m MemoryOutboxModel) update(ctx context.Context, id int64, fn func(email *memoryOutboxEmail)) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	email, found := m.store.outbox[id]
+	if !found {
+		return nil
+	}
+	fn(&email)
+	m.store.outbox[id] = email
+	return nil
+}
+
+// returns a copy of the email with its template data decoded
+func (e memoryOutboxEmail) decode() (*OutboxEmail, error) {
+	email := e.OutboxEmail
+	err := json.Unmarshal(e.data, &email.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &email, nil
+}
+
+type MemoryJobModel struct {
+	store *memoryStore
+}
+
+func (m MemoryJobModel) Insert(ctx context.Context, job *Job) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.lastJobID++
+	job.ID = m.store.lastJobID
+	job.CreatedAt = time.Now()
+	job.Status = JobPending
+	if job.RunAt.IsZero() {
+		job.RunAt = job.CreatedAt
+	}
+	if job.Payload == nil {
+		job.Payload = json.RawMessage("{}")
+	}
+	stored := *job
+	stored.Payload = append(json.RawMessage(nil), job.Payload...)
+	m.store.jobs[job.ID] = stored
+	return nil
+}
+
+func (m MemoryJobModel) Get(ctx context.Context, id int64) (*Job, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	job, found := m.store.jobs[id]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+	job.Payload = append(json.RawMessage(nil), job.Payload...)
+	return &job, nil
+}
+
+func (m MemoryJobModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]*Job, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	now := time.Now()
+	var due []Job
+	for _, job := range m.store.jobs {
+		if job.Status == JobPending && !job.RunAt.After(now) {
+			due = append(due, job)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].RunAt.Equal(due[j].RunAt) {
+			return due[i].ID < due[j].ID
+		}
+		return due[i].RunAt.Before(due[j].RunAt)
+	})
+
+	jobs := []*Job{}
+	for _, job := range due[:min(limit, len(due))] {
+		job.Attempts++
+		job.RunAt = now.Add(lease)
+		m.store.jobs[job.ID] = job
+		job.Payload = append(json.RawMessage(nil), job.Payload...)
+		jobs = append(jobs, &job)
+	}
+	return jobs, nil
+}
+
+func (m MemoryJobModel) MarkDone(ctx context.Context, id int64) error {
+	return m.update(ctx, id, func(job *Job) {
+		now := time.Now()
+		job.Status = JobDone
+		job.FinishedAt = &now
+		job.LastError = ""
+	})
+}
+
+func (m MemoryJobModel) MarkFailed(ctx context.Context, id int64, lastError string) error {
+	return m.update(ctx, id, func(job *Job) {
+		now := time.Now()
+		job.Status = JobFailed
+		job.FinishedAt = &now
+		job.LastError = lastError
+	})
+}
+
+func (m MemoryJobModel) Reschedule(ctx context.Context, id int64, lastError string, runAt time.Time) error {
+	return m.update(ctx, id, func(job *Job) {
+		job.LastError = lastError
+		job.RunAt = runAt
+	})
+}
+
+// apply fn to the stored job with id, missing jobs are ignored like an UPDATE
+func (m MemoryJobModel) update(ctx context.Context, id int64, fn func(job *Job)) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	job, found := m.store.jobs[id]
+	if !found {
+		return nil
+	}
+	fn(&job)
+	m.store.jobs[id] = job
+	return nil
+}
+
+// split s into lower case words, similar to the 'simple' text search configuration
+func splitWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// check if all values in want are present in have
+func containsAll(have, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if h == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
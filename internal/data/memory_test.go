@@ -0,0 +1,106 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryWithTx(t *testing.T) {
+	ctx := context.Background()
+	models := NewMemoryModels()
+	errRollback := errors.New("rollback")
+
+	tests := []struct {
+		name      string
+		email     string
+		err       error
+		wantFound bool
+	}{
+		{"Commit", "alice@example.com", nil, true},
+		{"Rollback", "bob@example.com", errRollback, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := models.WithTx(ctx, func(tx Models) error {
+				user := &User{Name: "Test User", Email: tt.email}
+				err := tx.Users.Insert(ctx, user)
+				if err != nil {
+					return err
+				}
+				// nested calls run in the same transaction
+				return tx.WithTx(ctx, func(tx Models) error {
+					err := tx.Roles.AddForUser(ctx, user.ID, "viewer")
+					if err != nil {
+						return err
+					}
+					_, err = tx.Tokens.New(ctx, user.ID, time.Hour, ScopeActivation)
+					if err != nil {
+						return err
+					}
+					return tt.err
+				})
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v; want %v", err, tt.err)
+			}
+			user, err := models.Users.GetByEmail(ctx, tt.email)
+			if found := err == nil; found != tt.wantFound {
+				t.Fatalf("user found %t; want %t", found, tt.wantFound)
+			}
+			if !tt.wantFound {
+				return
+			}
+			roles, err := models.Roles.GetAllForUser(ctx, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(roles) != 1 || roles[0] != "viewer" {
+				t.Errorf("got roles %v; want [viewer]", roles)
+			}
+		})
+	}
+}
+
+func TestMemoryWithTxConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	models := NewMemoryModels()
+	errRollback := errors.New("rollback")
+
+	found := make(chan bool)
+	inserted := make(chan error)
+	err := models.WithTx(ctx, func(tx Models) error {
+		err := tx.Users.Insert(ctx, &User{Name: "Alice", Email: "alice@example.com"})
+		if err != nil {
+			return err
+		}
+		// other callers run once the transaction has ended
+		go func() {
+			_, err := models.Users.GetByEmail(ctx, "alice@example.com")
+			found <- err == nil
+		}()
+		go func() {
+			inserted <- models.Users.Insert(ctx, &User{Name: "Bob", Email: "bob@example.com"})
+		}()
+		time.Sleep(10 * time.Millisecond)
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("got error %v; want %v", err, errRollback)
+	}
+	if <-found {
+		t.Error("uncommitted user read outside of the transaction")
+	}
+	if err := <-inserted; err != nil {
+		t.Fatal(err)
+	}
+
+	// the rollback only undid the writes of the transaction
+	if _, err := models.Users.GetByEmail(ctx, "alice@example.com"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got error %v for the rolled back user; want %v", err, ErrRecordNotFound)
+	}
+	if _, err := models.Users.GetByEmail(ctx, "bob@example.com"); err != nil {
+		t.Errorf("got error %v for the user inserted during the transaction", err)
+	}
+}
@@ -1,27 +1,205 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
+
+	"github.com/anukuljoshi/greenlight/internal/tracing"
 )
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	// returned when the context passed to a model method is canceled,
+	// e.g. because the client disconnected or the server is shutting down
+	ErrCanceled = errors.New("query canceled")
 )
 
+// timeout used for a single query when a model has no Timeout set
+const DefaultQueryTimeout = 3 * time.Second
+
+type MovieModelInterface interface {
+	Insert(ctx context.Context, movie *Movie) error
+	Get(ctx context.Context, id int64) (*Movie, error)
+	GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error)
+	Update(ctx context.Context, movie *Movie) error
+	Delete(ctx context.Context, id int64) error
+}
+
+type UserModelInterface interface {
+	Insert(ctx context.Context, user *User) error
+	Get(ctx context.Context, id int64) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+	Delete(ctx context.Context, id int64) error
+	DeleteUnactivated(ctx context.Context, createdBefore time.Time) (int64, error)
+}
+
+type TokenModelInterface interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	NewSession(ctx context.Context, userID int64, ttl time.Duration, ip, userAgent string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	DeleteForToken(ctx context.Context, scope, tokenPlaintext string) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+	Touch(ctx context.Context, scope, tokenPlaintext string) error
+	GetAllSessionsForUser(ctx context.Context, userID int64) ([]*Session, error)
+	DeleteSessionForUser(ctx context.Context, id, userID int64) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type PermissionModelInterface interface {
+	GetAll(ctx context.Context) (Permissions, error)
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+	RemoveForUser(ctx context.Context, userID int64, codes ...string) error
+}
+
+type RoleModelInterface interface {
+	GetAll(ctx context.Context) ([]*Role, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]string, error)
+	AddForUser(ctx context.Context, userID int64, names ...string) error
+	RemoveForUser(ctx context.Context, userID int64, names ...string) error
+}
+
+type EmailChangeModelInterface interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, newEmail string) (*Token, error)
+	GetForToken(ctx context.Context, tokenPlaintext string) (*User, string, error)
+}
+
+type OutboxModelInterface interface {
+	Insert(ctx context.Context, email *OutboxEmail) error
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEmail, error)
+	MarkSent(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, lastError string) error
+	Reschedule(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error
+	Retry(ctx context.Context, id int64) error
+	GetAll(ctx context.Context, status string, filters Filters) ([]*OutboxEmail, Metadata, error)
+}
+
+type JobModelInterface interface {
+	Insert(ctx context.Context, job *Job) error
+	Get(ctx context.Context, id int64) (*Job, error)
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*Job, error)
+	MarkDone(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, lastError string) error
+	Reschedule(ctx context.Context, id int64, lastError string, runAt time.Time) error
+}
+
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Movies       MovieModelInterface
+	Users        UserModelInterface
+	Tokens       TokenModelInterface
+	Permissions  PermissionModelInterface
+	Roles        RoleModelInterface
+	EmailChanges EmailChangeModelInterface
+	Outbox       OutboxModelInterface
+	Jobs         JobModelInterface
+
+	// runs a function with models bound to a single transaction
+	withTx func(ctx context.Context, fn func(tx Models) error) error
+}
+
+// DBTX is the subset of *sql.DB and *sql.Tx used by the models
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
-func NewModels(db *sql.DB) Models {
+// returns models backed by PostgreSQL
+// cache may be nil to disable caching of permissions and token lookups
+// timeout bounds each query on top of the context passed to model methods
+func NewModels(db *sql.DB, cache *Cache, timeout time.Duration) Models {
+	return newModels(db, cache, timeout)
+}
+
+func newModels(db DBTX, cache *Cache, timeout time.Duration) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		Movies:       MovieModel{DB: db, Timeout: timeout},
+		Users:        UserModel{DB: db, Cache: cache, Timeout: timeout},
+		Tokens:       TokenModel{DB: db, Cache: cache, Timeout: timeout},
+		Permissions:  PermissionModel{DB: db, Cache: cache, Timeout: timeout},
+		Roles:        RoleModel{DB: db, Cache: cache, Timeout: timeout},
+		EmailChanges: EmailChangeModel{DB: db, Timeout: timeout},
+		Outbox:       OutboxModel{DB: db, Timeout: timeout},
+		Jobs:         JobModel{DB: db, Timeout: timeout},
+		withTx: func(ctx context.Context, fn func(tx Models) error) error {
+			txCache, end := cache.beginTx()
+			defer end()
+			return inTx(ctx, db, func(tx DBTX) error {
+				return fn(newModels(tx, txCache, timeout))
+			})
+		},
+	}
+}
+
+// WithTx calls fn with models which share a single transaction. The
+// transaction is committed if fn returns nil and rolled back otherwise.
+// Calling WithTx on models which are already bound to a transaction runs fn
+// in that same transaction.
+func (m Models) WithTx(ctx context.Context, fn func(tx Models) error) error {
+	if m.withTx == nil {
+		return fn(m)
+	}
+	return m.withTx(ctx, fn)
+}
+
+// run fn in a new transaction if db is a connection pool, or directly if db
+// already is a transaction
+func inTx(ctx context.Context, db DBTX, fn func(tx DBTX) error) error {
+	pool, ok := db.(*sql.DB)
+	if !ok {
+		return fn(db)
+	}
+	tx, err := pool.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	// rollback is a no-op once the transaction is committed
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	return queryError(ctx, tx.Commit())
+}
+
+type querySpanKey struct{}
+
+// derive a context for a single query from ctx, bounded by timeout
+// the query is recorded in a span called name, which is ended by cancel
+func queryContext(ctx context.Context, timeout time.Duration, name string) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+	ctx, span := tracing.Start(ctx, name,
+		tracing.WithKind(tracing.KindClient),
+		tracing.WithAttributes(tracing.String("db.system", "postgresql")),
+	)
+	ctx = context.WithValue(ctx, querySpanKey{}, span)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		span.End()
+	}
+}
+
+// return ErrCanceled instead of err if the query context was canceled
+// a query timeout is reported as context.DeadlineExceeded and left unchanged
+func queryError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	// only record on spans of queries, ctx may be the context of a request
+	if span, _ := ctx.Value(querySpanKey{}).(*tracing.Span); span != nil {
+		span.RecordError(err)
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return ErrCanceled
 	}
+	return err
 }
@@ -0,0 +1,62 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+	"github.com/anukuljoshi/greenlight/internal/tracing"
+)
+
+// exporter which keeps every exported span
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) Export(ctx context.Context, spans []tracing.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestQuerySpan(t *testing.T) {
+	rec := &spanRecorder{}
+	tracer := tracing.New(rec, jsonlog.New(io.Discard, jsonlog.LevelOff), tracing.Config{SampleRatio: 1})
+	ctx, root := tracer.Start(context.Background(), "request")
+
+	queryCtx, cancel := queryContext(ctx, 0, "MovieModel.Get")
+	err := queryError(queryCtx, errors.New("connection reset"))
+	cancel()
+	// errors of a transaction are not recorded on the request span
+	queryError(ctx, errors.New("commit failed"))
+	root.End()
+
+	stopped, stop := context.WithCancel(context.Background())
+	stop()
+	tracer.Run(stopped)
+
+	if err == nil || err.Error() != "connection reset" {
+		t.Errorf("got error %v; want connection reset", err)
+	}
+	if len(rec.spans) != 2 {
+		t.Fatalf("got %d spans; want 2", len(rec.spans))
+	}
+	query, request := rec.spans[0], rec.spans[1]
+	if query.Name != "MovieModel.Get" || query.Kind != tracing.KindClient {
+		t.Errorf("got span %q of kind %s; want MovieModel.Get of kind client", query.Name, query.Kind)
+	}
+	if query.ParentSpanID != request.SpanContext.SpanID {
+		t.Errorf("got parent %s; want %s", query.ParentSpanID, request.SpanContext.SpanID)
+	}
+	if query.Error != "connection reset" {
+		t.Errorf("got query error %q; want %q", query.Error, "connection reset")
+	}
+	if request.Error != "" {
+		t.Errorf("got request error %q; want none", request.Error)
+	}
+}
@@ -43,24 +43,26 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 }
 
 type MovieModel struct {
-	DB *sql.DB
+	DB      DBTX
+	Timeout time.Duration
 }
 
 // create a movie instance in db
-func (m MovieModel) Insert(movie *Movie) error {
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
 	query := `
 		INSERT INTO movies (title, year, runtime, genres)
 		VALUES ($1, $2, $3, $4)
 		RETURNING id, created_at, version
 	`
 	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout, "MovieModel.Insert")
 	defer cancel()
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return queryError(ctx, err)
 }
 
 // retrieve a movie record with id from db
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
 	query := fmt.Sprintf(
 		`
 			SELECT count(*) OVER(), id, title, year, runtime, genres, created_at, version
@@ -74,8 +76,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 		filters.SortDirection(),
 	)
 
-	// use context.WithTimeout() to create context with 3 second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout, "MovieModel.GetAll")
 	defer cancel()
 
 	args := []any{
@@ -86,7 +87,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	}
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, queryError(ctx, err)
 	}
 	defer rows.Close()
 
@@ -105,13 +106,13 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&tempMovie.Version,
 		)
 		if err != nil {
-			return nil, Metadata{}, err
+			return nil, Metadata{}, queryError(ctx, err)
 		}
 		movies = append(movies, &tempMovie)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, queryError(ctx, err)
 	}
 	// get metadata using calculateMetadata function
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
@@ -119,7 +120,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 }
 
 // retrieve a movie record with id from db
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -129,8 +130,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		WHERE id = $1;
 	`
 	var movie Movie
-	// use context.WithTimeout() to create context with 3 second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout, "MovieModel.Get")
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&movie.ID,
@@ -146,14 +146,14 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 	}
 	return &movie, nil
 }
 
 // update a movie record with id from db
-func (m MovieModel) Update(movie *Movie) error {
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
 	query := `
 		UPDATE movies
 		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
@@ -168,7 +168,7 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.ID,
 		movie.Version,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout, "MovieModel.Update")
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
@@ -176,14 +176,14 @@ func (m MovieModel) Update(movie *Movie) error {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return err
+			return queryError(ctx, err)
 		}
 	}
 	return nil
 }
 
 // delete a movie record with id from db
-func (m MovieModel) Delete(id int64) error {
+func (m MovieModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
@@ -191,15 +191,15 @@ func (m MovieModel) Delete(id int64) error {
 		DELETE FROM movies
 		WHERE id = $1;
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout, "MovieModel.Delete")
 	defer cancel()
 	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
-		return err
+		return queryError(ctx, err)
 	}
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return err
+		return queryError(ctx, err)
 	}
 	if rowsAffected == 0 {
 		return ErrRecordNotFound
@@ -0,0 +1,245 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// OutboxEmail is an email waiting to be delivered by the outbox worker
+// Data holds template values such as tokens and is never sent to clients
+// TraceParent links the delivery to the trace of the request which queued it
+type OutboxEmail struct {
+	ID            int64          `json:"id"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Recipient     string         `json:"recipient"`
+	Template      string         `json:"template"`
+	Data          map[string]any `json:"-"`
+	Status        string         `json:"status"`
+	Attempts      int            `json:"attempts"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	LastError     string         `json:"last_error,omitempty"`
+	SentAt        *time.Time     `json:"sent_at,omitempty"`
+	TraceParent   string         `json:"-"`
+}
+
+type OutboxModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+const outboxColumns = `id, created_at, recipient, template, data, status, attempts, next_attempt_at, last_error, sent_at, traceparent`
+
+// scan a row selected with outboxColumns
+func scanOutboxEmail(row interface{ Scan(...any) error }, email *OutboxEmail) error {
+	var js []byte
+	err := row.Scan(
+		&email.ID,
+		&email.CreatedAt,
+		&email.Recipient,
+		&email.Template,
+		&js,
+		&email.Status,
+		&email.Attempts,
+		&email.NextAttemptAt,
+		&email.LastError,
+		&email.SentAt,
+		&email.TraceParent,
+	)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, &email.Data)
+}
+
+// queue an email for delivery, the email is due immediately
+func (m OutboxModel) Insert(ctx context.Context, email *OutboxEmail) error {
+	js, err := json.Marshal(email.Data)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO email_outbox (recipient, template, data, traceparent)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, status, next_attempt_at;
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout, "OutboxModel.Insert")
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, email.Recipient, email.Template, js, email.TraceParent).Scan(
+		&email.ID,
+		&email.CreatedAt,
+		&email.Status,
+		&email.NextAttemptAt,
+	)
+	return queryError(ctx, err)
+}
+
+// claim up to limit due emails for delivery and count the attempt
+// claimed emails are not due again until lease has passed, so an email is
+// retried if the worker stops before marking it sent or failed
+// rows locked by another worker are skipped
+func (m OutboxModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEmail, error) {
+	query := `
+		UPDATE email_outbox
+		SET attempts = attempts + 1, next_attempt_at = $3
+		WHERE id IN (
+			SELECT id
+			FROM email_outbox
+			WHERE status = $1
+			AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at, id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + outboxColumns + `;
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout, "OutboxModel.Claim")
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, OutboxPending, limit, time.Now().Add(lease))
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	emails := []*OutboxEmail{}
+	for rows.Next() {
+		var email OutboxEmail
+		err := scanOutboxEmail(rows, &email)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		emails = append(emails, &email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return emails, nil
+}
+
+// mark an email as delivered, template data is cleared as it may contain tokens
+func (m OutboxModel) MarkSent(ctx context.Context, id int64) error {
+	query := `
+		UPDATE email_outbox
+		SET status = $1, sent_at = NOW(), last_error = '', data = '{}'
+		WHERE id = $2;
+	`
+	return m.exec(ctx, "OutboxModel.MarkSent", query, OutboxSent, id)
+}
+
+// move an email to the dead-letter state, it is not retried until Retry is called
+func (m OutboxModel) MarkFailed(ctx context.Context, id int64, lastError string) error {
+	query := `
+		UPDATE email_outbox
+		SET status = $1, last_error = $2
+		WHERE id = $3;
+	`
+	return m.exec(ctx, "OutboxModel.MarkFailed", query, OutboxFailed, lastError, id)
+}
+
+// record a failed attempt and schedule the next one
+func (m OutboxModel) Reschedule(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error {
+	query := `
+		UPDATE email_outbox
+		SET last_error = $1, next_attempt_at = $2
+		WHERE id = $3;
+	`
+	return m.exec(ctx, "OutboxModel.Reschedule", query, lastError, nextAttemptAt, id)
+}
+
+// queue a failed email for delivery again with a fresh set of attempts
+// returns ErrRecordNotFound if there is no failed email with id
+func (m OutboxModel) Retry(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		UPDATE email_outbox
+		SET status = $1, attempts = 0, next_attempt_at = NOW()
+		WHERE id = $2
+		AND status = $3;
+	`
+
+	ctx, cancel := queryContext(ctx, m.Timeout, "OutboxModel.Retry")
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, OutboxPending, id, OutboxFailed)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// list emails with status, or all emails if status is empty
+func (m OutboxModel) GetAll(ctx context.Context, status string, filters Filters) ([]*OutboxEmail, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM email_outbox
+		WHERE (status = $1 OR $1 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3;
+	`,
+		outboxColumns,
+		filters.SortColumn(),
+		filters.SortDirection(),
+	)
+
+	ctx, cancel := queryContext(ctx, m.Timeout, "OutboxModel.GetAll")
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, filters.GetLimit(), filters.GetOffset())
+	if err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	emails := []*OutboxEmail{}
+	for rows.Next() {
+		var email OutboxEmail
+		err := scanOutboxEmail(scanFunc(func(dest ...any) error {
+			return rows.Scan(append([]any{&totalRecords}, dest...)...)
+		}), &email)
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+		emails = append(emails, &email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return emails, metadata, nil
+}
+
+func (m OutboxModel) exec(ctx context.Context, name, query string, args ...any) error {
+	ctx, cancel := queryContext(ctx, m.Timeout, name)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return queryError(ctx, err)
+}
+
+// adapts a function to the Scan method of *sql.Row and *sql.Rows
+type scanFunc func(dest ...any) error
+
+func (f scanFunc) Scan(dest ...any) error {
+	return f(dest...)
+}
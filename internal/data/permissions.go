@@ -2,7 +2,6 @@ package data
 
 import (
 	"context"
-	"database/sql"
 	"time"
 
 	"github.com/lib/pq"
@@ -21,48 +20,110 @@ func (p Permissions) Include(code string) bool {
 }
 
 type PermissionModel struct {
-	DB *sql.DB
+	DB      DBTX
+	Cache   *Cache
+	Timeout time.Duration
 }
 
-// returns all permissions for a user
-func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+// returns all permission codes
+func (m PermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY code;
+	`
+	ctx, cancel := queryContext(ctx, m.Timeout, "PermissionModel.GetAll")
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	var permissions = Permissions{}
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return permissions, nil
+}
+
+// returns all permissions for a user, both granted directly and through roles
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	permissions, gen, ok := m.Cache.getPermissions(userID)
+	if ok {
+		return permissions, nil
+	}
 	query := `
 		SELECT permissions.code
 		FROM permissions
 		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-		INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = $1;
+		WHERE users_permissions.user_id = $1
+		UNION
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = $1;
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout, "PermissionModel.GetAllForUser")
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
 	if err != nil {
-		return nil, err
+		return nil, queryError(ctx, err)
 	}
 	defer rows.Close()
 
-	var permissions Permissions
 	for rows.Next() {
 		var permission string
 		err := rows.Scan(&permission)
 		if err != nil {
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 		permissions = append(permissions, permission)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	m.Cache.setPermissions(userID, permissions, gen)
 	return permissions, nil
 }
 
 // add one or more permissions for a user
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	query := `
 		INSERT INTO users_permissions
 		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING
+	`
+	ctx, cancel := queryContext(ctx, m.Timeout, "PermissionModel.AddForUser")
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	m.Cache.invalidatePermissions(userID)
+	return queryError(ctx, err)
+}
+
+// remove one or more permissions for a user
+func (m PermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+		DELETE FROM users_permissions
+		WHERE user_id = $1
+		AND permission_id IN (SELECT permissions.id FROM permissions WHERE permissions.code = ANY($2))
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout, "PermissionModel.RemoveForUser")
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
+	m.Cache.invalidatePermissions(userID)
+	return queryError(ctx, err)
 }
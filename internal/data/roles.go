@@ -0,0 +1,119 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// named bundle of permission codes
+type Role struct {
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
+type RoleModel struct {
+	DB      DBTX
+	Cache   *Cache
+	Timeout time.Duration
+}
+
+// returns all roles with the permissions they grant
+func (m RoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	query := `
+		SELECT roles.name, COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+		FROM roles
+		LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+		LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+		GROUP BY roles.id, roles.name
+		ORDER BY roles.name;
+	`
+	ctx, cancel := queryContext(ctx, m.Timeout, "RoleModel.GetAll")
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	var roles = []*Role{}
+	for rows.Next() {
+		var role Role
+		var codes []string
+		err := rows.Scan(&role.Name, pq.Array(&codes))
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		role.Permissions = Permissions(codes)
+		roles = append(roles, &role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return roles, nil
+}
+
+// returns names of all roles assigned to a user
+func (m RoleModel) GetAllForUser(ctx context.Context, userID int64) ([]string, error) {
+	query := `
+		SELECT roles.name
+		FROM roles
+		INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		ORDER BY roles.name;
+	`
+	ctx, cancel := queryContext(ctx, m.Timeout, "RoleModel.GetAllForUser")
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	var roles = []string{}
+	for rows.Next() {
+		var role string
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		roles = append(roles, role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return roles, nil
+}
+
+// assign one or more roles to a user
+func (m RoleModel) AddForUser(ctx context.Context, userID int64, names ...string) error {
+	query := `
+		INSERT INTO users_roles
+		SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+		ON CONFLICT DO NOTHING
+	`
+	ctx, cancel := queryContext(ctx, m.Timeout, "RoleModel.AddForUser")
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	m.Cache.invalidatePermissions(userID)
+	return queryError(ctx, err)
+}
+
+// remove one or more roles from a user
+func (m RoleModel) RemoveForUser(ctx context.Context, userID int64, names ...string) error {
+	query := `
+		DELETE FROM users_roles
+		WHERE user_id = $1
+		AND role_id IN (SELECT roles.id FROM roles WHERE roles.name = ANY($2))
+	`
+	ctx, cancel := queryContext(ctx, m.Timeout, "RoleModel.RemoveForUser")
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	m.Cache.invalidatePermissions(userID)
+	return queryError(ctx, err)
+}
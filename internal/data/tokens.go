@@ -1,10 +1,10 @@
 package data
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/base32"
 	"time"
 
@@ -14,6 +14,8 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeEmailChange    = "email-change"
 )
 
 type Token struct {
@@ -22,6 +24,26 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	IP        string    `json:"-"`
+	UserAgent string    `json:"-"`
+}
+
+// Session describes an authentication token without exposing its hash
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	Current    bool       `json:"current"`
+	Hash       []byte     `json:"-"`
+}
+
+// check if session belongs to the plaintext token
+func (s *Session) MatchesToken(tokenPlaintext string) bool {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	return bytes.Equal(s.Hash, hash[:])
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -53,45 +75,192 @@ func ValidateTokenPlainText(v *validator.Validator, tokenPlainText string) {
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB      DBTX
+	Cache   *Cache
+	Timeout time.Duration
 }
 
 // creates new token and inserts data into tokens table
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+// creates new authentication token recording the client it was issued to
+func (m TokenModel) NewSession(ctx context.Context, userID int64, ttl time.Duration, ip, userAgent string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+	token.IP = ip
+	token.UserAgent = userAgent
+	err = m.Insert(ctx, token)
 	return token, err
 }
 
 // inserts data for token into db
-func (m TokenModel) Insert(token *Token) error {
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4);
+		INSERT INTO tokens (hash, user_id, expiry, scope, ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6);
 	`
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.IP, token.UserAgent}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout, "TokenModel.Insert")
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, args...)
-	return err
+	return queryError(ctx, err)
+}
+
+// delete a single token with scope matching the plaintext token
+func (m TokenModel) DeleteForToken(ctx context.Context, scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2;
+	`
+
+	args := []any{tokenHash[:], scope}
+	ctx, cancel := queryContext(ctx, m.Timeout, "TokenModel.DeleteForToken")
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	m.Cache.invalidateToken(tokenHash)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
 }
 
 // delete all token for a user
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
 		DELETE FROM tokens
 		WHERE scope = $1 AND user_id = $2;
 	`
 
 	args := []any{scope, userID}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout, "TokenModel.DeleteAllForUser")
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	m.Cache.invalidateUserTokens(userID)
+	return queryError(ctx, err)
+}
+
+// set last used time of a token to now
+func (m TokenModel) Touch(ctx context.Context, scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+		UPDATE tokens
+		SET last_used_at = NOW()
+		WHERE hash = $1 AND scope = $2;
+	`
+
+	args := []any{tokenHash[:], scope}
+	ctx, cancel := queryContext(ctx, m.Timeout, "TokenModel.Touch")
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, args...)
-	return err
+	return queryError(ctx, err)
+}
+
+// returns all unexpired authentication tokens for a user as sessions
+func (m TokenModel) GetAllSessionsForUser(ctx context.Context, userID int64) ([]*Session, error) {
+	query := `
+		SELECT id, created_at, last_used_at, expiry, ip, user_agent, hash
+		FROM tokens
+		WHERE scope = $1 AND user_id = $2 AND expiry > $3
+		ORDER BY created_at DESC, id DESC;
+	`
+	args := []any{ScopeAuthentication, userID, time.Now()}
+	ctx, cancel := queryContext(ctx, m.Timeout, "TokenModel.GetAllSessionsForUser")
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	var sessions = []*Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.IP,
+			&session.UserAgent,
+			&session.Hash,
+		)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return sessions, nil
+}
+
+// delete a single authentication token with id belonging to a user
+func (m TokenModel) DeleteSessionForUser(ctx context.Context, id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM tokens
+		WHERE id = $1 AND scope = $2 AND user_id = $3;
+	`
+
+	args := []any{id, ScopeAuthentication, userID}
+	ctx, cancel := queryContext(ctx, m.Timeout, "TokenModel.DeleteSessionForUser")
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	m.Cache.invalidateUserTokens(userID)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// delete tokens of every scope which have expired
+// returns the number of deleted tokens
+// cached lookups need no invalidation as they never outlive the token expiry
+func (m TokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE expiry < $1;
+	`
+	ctx, cancel := queryContext(ctx, m.Timeout, "TokenModel.DeleteExpired")
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+	return result.RowsAffected()
 }
@@ -91,18 +91,20 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 // create userModel struct wrapping the connection pool
 type UserModel struct {
-	DB *sql.DB
+	DB      DBTX
+	Cache   *Cache
+	Timeout time.Duration
 }
 
 // insert a new user into db
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
 		INSERT INTO users (name, email, password_hash, activated)
 		VALUES ($1, $2, $3, $4)
 		RETURNING id, created_at, version;
 	`
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout, "UserModel.Insert")
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
@@ -110,19 +112,57 @@ func (m UserModel) Insert(user *User) error {
 		if err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"` {
 			return ErrDuplicateEmail
 		}
-		return err
+		return queryError(ctx, err)
 	}
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+// retrieve a user record with id from db
+func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE id = $1;
+	`
+	var user User
+	ctx, cancel := queryContext(ctx, m.Timeout, "UserModel.Get")
+	defer cancel()
+
+	err := m.DB.QueryRowContext(
+		ctx,
+		query,
+		id,
+	).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &user, nil
+}
+
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
 		SELECT id, created_at, name, email, password_hash, activated, version
 		FROM users
 		WHERE email = $1;
 	`
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout, "UserModel.GetByEmail")
 	defer cancel()
 
 	err := m.DB.QueryRowContext(
@@ -143,13 +183,13 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 	}
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
 		UPDATE users
 		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -164,7 +204,7 @@ func (m UserModel) Update(user *User) error {
 		user.ID,
 		user.Version,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout, "UserModel.Update")
 	defer cancel()
 	err := m.DB.QueryRowContext(
 		ctx,
@@ -173,22 +213,35 @@ func (m UserModel) Update(user *User) error {
 	).Scan(
 		&user.Version,
 	)
+	// cached token lookups hold a copy of the user which is now stale
+	m.Cache.invalidateUserTokens(user.ID)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
 		default:
-			return err
+			return queryError(ctx, err)
 		}
 	}
 	return nil
 }
 
 // get user related to a token
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	// only authentication tokens are looked up often enough to be worth caching
+	var gen uint64
+	if tokenScope == ScopeAuthentication {
+		user, g, ok := m.Cache.getTokenUser(tokenHash)
+		if ok {
+			return user, nil
+		}
+		gen = g
+	}
 	query := `
-		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, tokens.expiry
 		FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
@@ -202,8 +255,9 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		time.Now(),
 	}
 	var user User
+	var expiry time.Time
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout, "UserModel.GetForToken")
 	defer cancel()
 
 	err := m.DB.QueryRowContext(
@@ -218,14 +272,80 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&expiry,
 	)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 	}
+	if tokenScope == ScopeAuthentication {
+		m.Cache.setTokenUser(tokenHash, &user, expiry, gen)
+	}
 	return &user, nil
 }
+
+// delete a user record with id from db
+func (m UserModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM users
+		WHERE id = $1;
+	`
+	ctx, cancel := queryContext(ctx, m.Timeout, "UserModel.Delete")
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	m.Cache.invalidateUserTokens(id)
+	m.Cache.invalidatePermissions(id)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// delete users which are not activated and were created before createdBefore
+// returns the number of deleted users
+func (m UserModel) DeleteUnactivated(ctx context.Context, createdBefore time.Time) (int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE activated = false
+		AND created_at < $1
+		RETURNING id;
+	`
+	ctx, cancel := queryContext(ctx, m.Timeout, "UserModel.DeleteUnactivated")
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, createdBefore)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	var deleted int64
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return deleted, queryError(ctx, err)
+		}
+		m.Cache.invalidateUserTokens(id)
+		m.Cache.invalidatePermissions(id)
+		deleted++
+	}
+	if err = rows.Err(); err != nil {
+		return deleted, queryError(ctx, err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,83 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+)
+
+// driver whose queries return no rows, like an UPDATE ... RETURNING which
+// matched no row
+type noRowsDriver struct{}
+
+func (noRowsDriver) Open(name string) (driver.Conn, error) {
+	return noRowsConn{}, nil
+}
+
+type noRowsConn struct{}
+
+func (noRowsConn) Prepare(query string) (driver.Stmt, error) {
+	return noRowsStmt{}, nil
+}
+
+func (noRowsConn) Close() error {
+	return nil
+}
+
+func (noRowsConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type noRowsStmt struct{}
+
+func (noRowsStmt) Close() error {
+	return nil
+}
+
+func (noRowsStmt) NumInput() int {
+	return -1
+}
+
+func (noRowsStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (noRowsStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return noRows{}, nil
+}
+
+type noRows struct{}
+
+func (noRows) Columns() []string {
+	return []string{"version"}
+}
+
+func (noRows) Close() error {
+	return nil
+}
+
+func (noRows) Next(dest []driver.Value) error {
+	return io.EOF
+}
+
+func init() {
+	sql.Register("norows", noRowsDriver{})
+}
+
+func TestUpdateUserEditConflict(t *testing.T) {
+	db, err := sql.Open("norows", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the version no longer matches, so the update returns no row
+	user := &User{ID: 1, Name: "Alice", Email: "alice@example.com", Version: 1}
+	err = UserModel{DB: db}.Update(context.Background(), user)
+	if !errors.Is(err, ErrEditConflict) {
+		t.Errorf("got error %v; want %v", err, ErrEditConflict)
+	}
+}
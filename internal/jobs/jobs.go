@@ -0,0 +1,223 @@
+// Package jobs runs background work stored with data.JobModelInterface.
+//
+// Handlers are registered for a job kind before the queue is started. Jobs are
+// claimed with row locks that skip jobs claimed by other workers, so several
+// application instances can share one jobs table.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/anukuljoshi/greenlight/internal/data"
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+)
+
+// upper bound for the delay between two attempts of a job
+const maxBackoff = time.Hour
+
+// Handler runs a single job, a returned error causes the job to be retried
+type Handler func(ctx context.Context, job *data.Job) error
+
+type Config struct {
+	// number of jobs run at the same time
+	Concurrency int
+	// time a worker waits before checking for due jobs again when none are left
+	PollInterval time.Duration
+	// attempts before a job is marked failed
+	MaxAttempts int
+	// delay before the first retry, doubled for every further attempt
+	Backoff time.Duration
+	// maximum run time of a job, a job which is still running after Timeout
+	// may be claimed again by another worker
+	Timeout time.Duration
+}
+
+type Queue struct {
+	store    data.JobModelInterface
+	logger   *jsonlog.Logger
+	config   Config
+	handlers map[string]Handler
+}
+
+// returns a queue which runs jobs from store
+func New(store data.JobModelInterface, logger *jsonlog.Logger, config Config) *Queue {
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = 1
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Minute
+	}
+	return &Queue{
+		store:    store,
+		logger:   logger,
+		config:   config,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Register sets the handler for jobs of kind, it must be called before the
+// queue is started
+func (q *Queue) Register(kind string, handler Handler) {
+	if _, exists := q.handlers[kind]; exists {
+		panic("jobs: handler registered twice for kind " + kind)
+	}
+	q.handlers[kind] = handler
+}
+
+// Handle returns a handler which decodes the job payload into a T and calls fn
+func Handle[T any](fn func(ctx context.Context, payload T) error) Handler {
+	return func(ctx context.Context, job *data.Job) error {
+		var payload T
+		err := json.Unmarshal(job.Payload, &payload)
+		if err != nil {
+			return fmt.Errorf("decoding payload: %w", err)
+		}
+		return fn(ctx, payload)
+	}
+}
+
+// Enqueue adds a job of kind with payload encoded as JSON to store, the job is
+// due at runAt or immediately if runAt is the zero time
+// pass the jobs model of models bound to a transaction to add the job only if
+// the transaction commits
+func Enqueue(ctx context.Context, store data.JobModelInterface, kind string, payload any, runAt time.Time) (*data.Job, error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	job := &data.Job{
+		Kind:    kind,
+		Payload: js,
+		RunAt:   runAt,
+	}
+	err = store.Insert(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Run starts the configured number of workers and blocks until ctx is
+// canceled. Workers stop claiming jobs once ctx is canceled, jobs which are
+// already running are completed before Run returns.
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < q.config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		ran, err := q.runNext(ctx)
+		if err != nil && !errors.Is(err, data.ErrCanceled) {
+			q.logger.Error(err)
+		}
+		// check again right away while there are due jobs
+		if ran {
+			timer.Reset(0)
+		} else {
+			timer.Reset(q.config.PollInterval)
+		}
+	}
+}
+
+// RunDue runs due jobs one after another until none are left
+func (q *Queue) RunDue(ctx context.Context) error {
+	for {
+		ran, err := q.runNext(ctx)
+		if err != nil || !ran {
+			return err
+		}
+	}
+}
+
+// claim and run a single due job, reports false if no job was due
+func (q *Queue) runNext(ctx context.Context) (bool, error) {
+	jobs, err := q.store.Claim(ctx, 1, q.config.Timeout)
+	if err != nil || len(jobs) == 0 {
+		return false, err
+	}
+	// a claimed job is completed even if ctx is canceled while it runs
+	q.run(context.WithoutCancel(ctx), jobs[0])
+	return true, nil
+}
+
+func (q *Queue) run(ctx context.Context, job *data.Job) {
+	logger := q.logger.With(
+		jsonlog.Int64("job_id", job.ID),
+		jsonlog.String("kind", job.Kind),
+		jsonlog.Int("attempts", job.Attempts),
+	)
+
+	handler, found := q.handlers[job.Kind]
+	if !found {
+		// there is nothing to retry without a handler
+		err := fmt.Errorf("no handler registered for job kind %q", job.Kind)
+		logger.Error(err)
+		q.record(q.store.MarkFailed(ctx, job.ID, err.Error()), logger)
+		return
+	}
+
+	runErr := q.call(ctx, handler, job)
+	switch {
+	case runErr == nil:
+		q.record(q.store.MarkDone(ctx, job.ID), logger)
+	case job.Attempts >= q.config.MaxAttempts:
+		logger.Error(runErr)
+		q.record(q.store.MarkFailed(ctx, job.ID, runErr.Error()), logger)
+	default:
+		logger.Error(runErr)
+		runAt := time.Now().Add(Backoff(q.config.Backoff, job.Attempts))
+		q.record(q.store.Reschedule(ctx, job.ID, runErr.Error(), runAt), logger)
+	}
+}
+
+// call handler with a timeout and turn a panic into an error
+func (q *Queue) call(ctx context.Context, handler Handler, job *data.Job) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, q.config.Timeout)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// log an error from storing the outcome of a job
+func (q *Queue) record(err error, logger *jsonlog.Logger) {
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+// Backoff returns the delay after the given number of failed attempts, base
+// is doubled for every attempt after the first up to one hour
+func Backoff(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
@@ -0,0 +1,174 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anukuljoshi/greenlight/internal/data"
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+)
+
+type greeting struct {
+	Name string `json:"name"`
+}
+
+func newTestQueue(t *testing.T) (*Queue, data.JobModelInterface) {
+	t.Helper()
+	store := data.NewMemoryModels().Jobs
+	q := New(store, jsonlog.New(io.Discard, jsonlog.LevelOff), Config{
+		Concurrency:  2,
+		PollInterval: 10 * time.Millisecond,
+		MaxAttempts:  3,
+	})
+	return q, store
+}
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{20, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := Backoff(30*time.Second, tt.attempts); got != tt.want {
+			t.Errorf("attempts %d: got %s; want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestRunDue(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	q, store := newTestQueue(t)
+
+	var greeted []string
+	q.Register("greet", Handle(func(ctx context.Context, g greeting) error {
+		greeted = append(greeted, g.Name)
+		return nil
+	}))
+	flakyCalls := 0
+	q.Register("flaky", func(ctx context.Context, job *data.Job) error {
+		flakyCalls++
+		if flakyCalls == 1 {
+			return errors.New("temporary failure")
+		}
+		return nil
+	})
+	q.Register("broken", func(ctx context.Context, job *data.Job) error {
+		return errors.New("permanent failure")
+	})
+	q.Register("panic", func(ctx context.Context, job *data.Job) error {
+		panic("boom")
+	})
+
+	tests := []struct {
+		kind         string
+		payload      any
+		wantStatus   string
+		wantAttempts int
+	}{
+		{"greet", greeting{Name: "Alice"}, data.JobDone, 1},
+		{"flaky", nil, data.JobDone, 2},
+		{"broken", nil, data.JobFailed, 3},
+		{"panic", nil, data.JobFailed, 3},
+		{"unknown", nil, data.JobFailed, 1},
+		{"greet", "not an object", data.JobFailed, 3},
+	}
+	ids := make([]int64, len(tests))
+	for i, tt := range tests {
+		job, err := Enqueue(ctx, store, tt.kind, tt.payload, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = job.ID
+	}
+	if err := q.RunDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range tests {
+		job, err := store.Get(ctx, ids[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != tt.wantStatus || job.Attempts != tt.wantAttempts {
+			t.Errorf("%s job %d: got status %s after %d attempts; want %s after %d",
+				tt.kind, job.ID, job.Status, job.Attempts, tt.wantStatus, tt.wantAttempts)
+		}
+	}
+	if len(greeted) != 1 || greeted[0] != "Alice" {
+		t.Errorf("got greetings %v; want [Alice]", greeted)
+	}
+}
+
+func TestRunAt(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	q, store := newTestQueue(t)
+	q.Register("greet", Handle(func(ctx context.Context, g greeting) error {
+		return nil
+	}))
+
+	job, err := Enqueue(ctx, store, "greet", greeting{Name: "Bob"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.RunDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	job, err = store.Get(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != data.JobPending || job.Attempts != 0 {
+		t.Errorf("scheduled job ran early: status %s after %d attempts", job.Status, job.Attempts)
+	}
+}
+
+func TestRunDrain(t *testing.T) {
+	t.Parallel()
+	q, store := newTestQueue(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	q.Register("slow", func(ctx context.Context, job *data.Job) error {
+		close(started)
+		<-release
+		return ctx.Err()
+	})
+	job, err := Enqueue(context.Background(), store, "slow", nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		q.Run(ctx)
+	}()
+
+	// cancel while the job runs, Run must wait for it to complete
+	<-started
+	cancel()
+	close(release)
+	wg.Wait()
+
+	job, err = store.Get(context.Background(), job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != data.JobDone {
+		t.Errorf("got status %s; want %s", job.Status, data.JobDone)
+	}
+}
@@ -1,12 +1,15 @@
 package jsonlog
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,17 +17,23 @@ import (
 type Level int8
 
 const (
+	LevelDebug Level = 0
 	LevelInfo  Level = 1
-	LevelError Level = 2
-	LevelFatal Level = 3
-	LevelOff   Level = 4
+	LevelWarn  Level = 2
+	LevelError Level = 3
+	LevelFatal Level = 4
+	LevelOff   Level = 5
 )
 
 // return a human-friendly string for severity level
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
@@ -36,60 +45,197 @@ func (l Level) String() string {
 	}
 }
 
+// parse a level name as returned by String, ignoring case
+func ParseLevel(s string) (Level, error) {
+	for l := LevelDebug; l <= LevelOff; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// MarshalText returns the lowercase name of l, e.g. for flag.TextVar
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(strings.ToLower(l.String())), nil
+}
+
+func (l *Level) UnmarshalText(text []byte) error {
+	level, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// Field is a typed value added to the properties of a log entry
+type Field struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int(key string, value int) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Float64(key string, value float64) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Duration is written in the format of time.Duration.String, e.g. "1.5s"
+func Duration(key string, value time.Duration) Field {
+	return Field{Key: key, Value: value.String()}
+}
+
+func Time(key string, value time.Time) Field {
+	return Field{Key: key, Value: value.UTC().Format(time.RFC3339Nano)}
+}
+
+// Err adds the message of err as "error", a nil err is written as null
+func Err(err error) Field {
+	if err == nil {
+		return Field{Key: "error", Value: nil}
+	}
+	return Field{Key: "error", Value: err.Error()}
+}
+
+// Object nests fields in an object under key
+func Object(key string, fields ...Field) Field {
+	return Field{Key: key, Value: fieldMap(fields)}
+}
+
+// Any adds a value which is encoded with encoding/json
+func Any(key string, value any) Field {
+	return Field{Key: key, Value: value}
+}
+
+// the key of the field returned by Stack, written next to the message
+// instead of in the properties
+const stackKey = "trace"
+
+// Stack adds the stack trace of the calling goroutine to an entry
+// entries only contain a stack trace if it is added explicitly or if they
+// are written with Fatal
+func Stack() Field {
+	return Field{Key: stackKey, Value: string(debug.Stack())}
+}
+
+func fieldMap(fields []Field) map[string]any {
+	m := make(map[string]any, len(fields))
+	for _, f := range fields {
+		m[f.Key] = f.Value
+	}
+	return m
+}
+
+// state shared by a logger and its child loggers
+type core struct {
+	mu       sync.Mutex
+	out      io.Writer
+	minLevel atomic.Int32
+}
+
 // define a custom logger
 type Logger struct {
-	out      io.Writer
-	minLevel Level
-	mu       sync.Mutex
+	core   *core
+	fields []Field
 }
 
 // return a new logger instance
 func New(out io.Writer, minLevel Level) *Logger {
+	c := &core{out: out}
+	c.minLevel.Store(int32(minLevel))
+	return &Logger{core: c}
+}
+
+// With returns a child logger which adds fields to every entry, the child
+// shares the output and the minimum level of l
+func (l *Logger) With(fields ...Field) *Logger {
 	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+		core:   l.core,
+		fields: append(append([]Field(nil), l.fields...), fields...),
 	}
 }
 
-// helper methods for writing info messages to logger
-func (l *Logger) PrintInfo(message string, properties map[string]string) {
-	l.print(LevelInfo, message, properties)
+// SetLevel changes the minimum level of l and of every logger sharing its output
+func (l *Logger) SetLevel(level Level) {
+	l.core.minLevel.Store(int32(level))
+}
+
+func (l *Logger) Level() Level {
+	return Level(l.core.minLevel.Load())
+}
+
+// Enabled reports whether entries of level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
 }
 
-// helper methods for writing error messages to logger
-func (l *Logger) PrintError(err error, properties map[string]string) {
-	l.print(LevelError, err.Error(), properties)
+func (l *Logger) Debug(message string, fields ...Field) {
+	l.print(LevelDebug, message, fields)
 }
 
-// helper methods for writing fatal messages to logger
-func (l *Logger) PrintFatal(err error, properties map[string]string) {
-	l.print(LevelFatal, err.Error(), properties)
+func (l *Logger) Info(message string, fields ...Field) {
+	l.print(LevelInfo, message, fields)
+}
+
+func (l *Logger) Warn(message string, fields ...Field) {
+	l.print(LevelWarn, message, fields)
+}
+
+// Error writes an entry with the message of err
+func (l *Logger) Error(err error, fields ...Field) {
+	l.print(LevelError, err.Error(), fields)
+}
+
+// Fatal writes an entry with the message of err and a stack trace, then exits
+func (l *Logger) Fatal(err error, fields ...Field) {
+	l.print(LevelFatal, err.Error(), append(fields, Stack()))
 	os.Exit(1)
 }
 
 // internal method for writing to logger
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
+func (l *Logger) print(level Level, message string, fields []Field) (int, error) {
 	// return without printing if severity is below minLevel
-	if level < l.minLevel {
+	if !l.Enabled(level) {
 		return 0, nil
 	}
 	// declare anonymous struct to hold data for log entry
 	aux := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
+		Level      string         `json:"level"`
+		Time       string         `json:"time"`
+		Message    string         `json:"message"`
+		Properties map[string]any `json:"properties,omitempty"`
+		Trace      string         `json:"trace,omitempty"`
 	}{
-		Level:      level.String(),
-		Time:       time.Now().UTC().Format(time.RFC3339),
-		Message:    message,
-		Properties: properties,
+		Level:   level.String(),
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Message: message,
 	}
 
-	// include stack trace for error and fatal entries
-	if level >= LevelError {
-		aux.Trace = string(debug.Stack())
+	// fields of the call override bound fields with the same key
+	if n := len(l.fields) + len(fields); n > 0 {
+		aux.Properties = make(map[string]any, n)
+		for _, f := range append(l.fields[:len(l.fields):len(l.fields)], fields...) {
+			if f.Key == stackKey {
+				aux.Trace, _ = f.Value.(string)
+				continue
+			}
+			aux.Properties[f.Key] = f.Value
+		}
 	}
 
 	// variable to hold actual log entry text
@@ -102,14 +248,43 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 	}
 
 	// lock mutex so that multiple log entries don't write concurrently
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
 
 	// write log entry followed by newline
-	return l.out.Write(append(line, '\n'))
+	return writeLevel(l.core.out, level, append(line, '\n'))
+}
+
+// LevelWriter is implemented by outputs which need the level of an entry,
+// e.g. to set the severity of a syslog message
+type LevelWriter interface {
+	io.Writer
+	WriteLevel(level Level, p []byte) (int, error)
+}
+
+func writeLevel(w io.Writer, level Level, p []byte) (int, error) {
+	if lw, ok := w.(LevelWriter); ok {
+		return lw.WriteLevel(level, p)
+	}
+	return w.Write(p)
 }
 
 // implement Write method on our logger so that it satisfies the io.Writer interface
 func (l *Logger) Write(message []byte) (n int, err error) {
-	return l.print(LevelError, string(message), nil)
+	return l.print(LevelError, strings.TrimSuffix(string(message), "\n"), nil)
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying l, e.g. a child logger bound to a request
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger of ctx, or fallback if ctx has none
+func FromContext(ctx context.Context, fallback *Logger) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return fallback
 }
@@ -0,0 +1,154 @@
+package jsonlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type entry struct {
+	Level      string         `json:"level"`
+	Message    string         `json:"message"`
+	Properties map[string]any `json:"properties"`
+	Trace      string         `json:"trace"`
+}
+
+func decode(t *testing.T, buf *bytes.Buffer) []entry {
+	t.Helper()
+	var entries []entry
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var e entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("decoding %q: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestParseLevel(t *testing.T) {
+	t.Parallel()
+	for _, s := range []string{"debug", "INFO", "Warn", "error", "fatal", "off"} {
+		level, err := ParseLevel(s)
+		if err != nil {
+			t.Errorf("ParseLevel(%q): %v", s, err)
+			continue
+		}
+		if !strings.EqualFold(level.String(), s) {
+			t.Errorf("ParseLevel(%q) = %s", s, level)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("got nil error for an unknown level")
+	}
+}
+
+func TestLevels(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	logger := New(&buf, LevelWarn)
+	child := logger.With(String("request_id", "abc"))
+
+	logger.Debug("debug")
+	logger.Info("info")
+	child.Warn("warn")
+	logger.Error(errors.New("failed"))
+	// the child shares the level of its parent
+	logger.SetLevel(LevelDebug)
+	child.Debug("debug after SetLevel")
+
+	entries := decode(t, &buf)
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Level+" "+e.Message)
+	}
+	want := []string{"WARN warn", "ERROR failed", "DEBUG debug after SetLevel"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got entries %q; want %q", got, want)
+	}
+	if child.Level() != LevelDebug {
+		t.Errorf("got child level %s; want DEBUG", child.Level())
+	}
+}
+
+func TestFields(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	logger := New(&buf, LevelInfo).With(String("request_id", "abc"), Int("attempt", 1))
+	child := logger.With(Int64("user_id", 7))
+
+	child.Info("done",
+		Int("attempt", 2),
+		Duration("duration", 1500*time.Millisecond),
+		Bool("cached", true),
+		Object("db", String("query", "MovieModel.Get"), Float64("ms", 0.5)),
+		Err(errors.New("timeout")),
+	)
+	logger.Info("parent")
+
+	entries := decode(t, &buf)
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries; want 2", len(entries))
+	}
+	props := entries[0].Properties
+	want := map[string]any{
+		"request_id": "abc",
+		"user_id":    float64(7),
+		"attempt":    float64(2),
+		"duration":   "1.5s",
+		"cached":     true,
+		"error":      "timeout",
+	}
+	for key, value := range want {
+		if props[key] != value {
+			t.Errorf("got %s %v; want %v", key, props[key], value)
+		}
+	}
+	if db, _ := props["db"].(map[string]any); db["query"] != "MovieModel.Get" || db["ms"] != 0.5 {
+		t.Errorf("got db %v", props["db"])
+	}
+	if entries[0].Trace != "" {
+		t.Error("got a stack trace without Stack")
+	}
+	// fields bound to the child are not added to the parent
+	if _, found := entries[1].Properties["user_id"]; found {
+		t.Errorf("got user_id in parent entry %v", entries[1].Properties)
+	}
+}
+
+func TestStack(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	logger := New(&buf, LevelInfo)
+	logger.Error(errors.New("panic"), Stack())
+
+	entries := decode(t, &buf)
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries; want 1", len(entries))
+	}
+	if !strings.Contains(entries[0].Trace, "TestStack") {
+		t.Errorf("got trace %q; want the stack of the caller", entries[0].Trace)
+	}
+	if _, found := entries[0].Properties["trace"]; found {
+		t.Error("got the stack trace in the properties")
+	}
+}
+
+func TestContext(t *testing.T) {
+	t.Parallel()
+	fallback := New(&bytes.Buffer{}, LevelInfo)
+	if got := FromContext(context.Background(), fallback); got != fallback {
+		t.Error("got a logger other than the fallback for an empty context")
+	}
+	child := fallback.With(String("request_id", "abc"))
+	if got := FromContext(NewContext(context.Background(), child), fallback); got != child {
+		t.Error("got a logger other than the one in the context")
+	}
+}
@@ -0,0 +1,47 @@
+package jsonlog
+
+import (
+	"errors"
+	"io"
+)
+
+type multiWriter struct {
+	writers []io.Writer
+}
+
+// MultiWriter returns a writer which writes every entry to all writers
+// unlike io.MultiWriter a failing writer does not stop the entry from being
+// written to the others, the errors of all writers are returned together
+func MultiWriter(writers ...io.Writer) io.Writer {
+	if len(writers) == 1 {
+		return writers[0]
+	}
+	return &multiWriter{writers: writers}
+}
+
+func (m *multiWriter) Write(p []byte) (int, error) {
+	var errs []error
+	for _, w := range m.writers {
+		_, err := w.Write(p)
+		errs = append(errs, err)
+	}
+	return m.result(p, errs)
+}
+
+// WriteLevel passes the level on to writers which implement LevelWriter
+func (m *multiWriter) WriteLevel(level Level, p []byte) (int, error) {
+	var errs []error
+	for _, w := range m.writers {
+		_, err := writeLevel(w, level, p)
+		errs = append(errs, err)
+	}
+	return m.result(p, errs)
+}
+
+func (m *multiWriter) result(p []byte, errs []error) (int, error) {
+	err := errors.Join(errs...)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
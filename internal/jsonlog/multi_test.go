@@ -0,0 +1,46 @@
+package jsonlog
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+// records the level of every entry
+type levelRecorder struct {
+	levels []Level
+}
+
+func (r *levelRecorder) Write(p []byte) (int, error) {
+	return r.WriteLevel(LevelError, p)
+}
+
+func (r *levelRecorder) WriteLevel(level Level, p []byte) (int, error) {
+	r.levels = append(r.levels, level)
+	return len(p), nil
+}
+
+func TestMultiWriter(t *testing.T) {
+	t.Parallel()
+	var first, second bytes.Buffer
+	rec := &levelRecorder{}
+	logger := New(MultiWriter(&first, failingWriter{}, rec, &second), LevelInfo)
+
+	_, err := logger.print(LevelWarn, "disk almost full", nil)
+	if err == nil || err.Error() != "disk full" {
+		t.Errorf("got error %v; want disk full", err)
+	}
+	// the failing writer does not stop the entry from reaching the others
+	if first.Len() == 0 || first.String() != second.String() {
+		t.Errorf("got entries %q and %q; want the same entry twice", first.String(), second.String())
+	}
+	if len(rec.levels) != 1 || rec.levels[0] != LevelWarn {
+		t.Errorf("got levels %v; want [WARN]", rec.levels)
+	}
+}
@@ -0,0 +1,188 @@
+package jsonlog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// layout of the time in the names of rotated files, it sorts in the order
+// the files were rotated
+const backupTimeFormat = "20060102T150405.000"
+
+type RotateConfig struct {
+	// the file is rotated before a write would make it larger than MaxSize
+	// bytes, 0 disables rotation by size
+	MaxSize int64
+	// the file is rotated on the first write in a new period of Interval,
+	// e.g. every day at midnight UTC for 24h, 0 disables rotation by time
+	Interval time.Duration
+	// rotated files beyond the newest MaxBackups are deleted, 0 keeps all
+	MaxBackups int
+	// rotated files older than MaxAge are deleted, 0 keeps all
+	MaxAge time.Duration
+}
+
+// RotatingFile is a log file which is renamed to path-<time>.ext and replaced
+// by an empty file once it grows too large or too old
+type RotatingFile struct {
+	path   string
+	config RotateConfig
+	now    func() time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// opens or creates the file at path and appends to it, the directory is
+// created if needed
+func NewRotatingFile(path string, config RotateConfig) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, config: config, now: time.Now}
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, err
+	}
+	err = f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	// an existing file belongs to the period of its last write
+	f.opened = f.now()
+	if f.size > 0 {
+		f.opened = info.ModTime()
+	}
+	return nil
+}
+
+// Write appends p to the file, rotating it first if needed
+// a single entry larger than MaxSize is written to an empty file
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.shouldRotate(int64(len(p))) {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) shouldRotate(n int64) bool {
+	if f.config.MaxSize > 0 && f.size+n > f.config.MaxSize {
+		return true
+	}
+	if f.config.Interval > 0 {
+		return !f.now().Truncate(f.config.Interval).Equal(f.opened.Truncate(f.config.Interval))
+	}
+	return false
+}
+
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+	f.file = nil
+	ext := filepath.Ext(f.path)
+	backup := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(f.path, ext), f.now().UTC().Format(backupTimeFormat), ext)
+	err = os.Rename(f.path, backup)
+	if err != nil {
+		return err
+	}
+	err = f.open()
+	if err != nil {
+		return err
+	}
+	return f.removeOld()
+}
+
+// delete rotated files beyond MaxBackups or older than MaxAge
+func (f *RotatingFile) removeOld() error {
+	if f.config.MaxBackups <= 0 && f.config.MaxAge <= 0 {
+		return nil
+	}
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i, backup := range backups {
+		expired := f.config.MaxAge > 0 && f.now().Sub(backup.rotated) > f.config.MaxAge
+		if expired || (f.config.MaxBackups > 0 && i >= f.config.MaxBackups) {
+			errs = append(errs, os.Remove(backup.path))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type backupFile struct {
+	path    string
+	rotated time.Time
+}
+
+// returns the rotated files of f, newest first
+func (f *RotatingFile) backups() ([]backupFile, error) {
+	ext := filepath.Ext(f.path)
+	prefix := filepath.Base(strings.TrimSuffix(f.path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, err
+	}
+	var backups []backupFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		// skip files of other logs sharing the prefix, e.g. api-errors.log
+		rotated, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{path: filepath.Join(filepath.Dir(f.path), name), rotated: rotated})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].rotated.After(backups[j].rotated)
+	})
+	return backups, nil
+}
+
+// Close closes the file, further writes fail
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,168 @@
+package jsonlog
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// returns the names of the files in dir, sorted
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRotateSize(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logs", "api.log")
+	f, err := NewRotatingFile(path, RotateConfig{MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	clock := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return clock }
+
+	f.Write([]byte("12345\n"))
+	f.Write([]byte("123\n"))
+	// 6+4 bytes fit, the next entry starts a new file
+	clock = clock.Add(time.Second)
+	f.Write([]byte("abc\n"))
+
+	want := []string{"api-20261017T120001.000.log", "api.log"}
+	if got := listDir(t, filepath.Join(dir, "logs")); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("got files %v; want %v", got, want)
+	}
+	if got := readFile(t, filepath.Join(dir, "logs", want[0])); got != "12345\n123\n" {
+		t.Errorf("got rotated file %q", got)
+	}
+	if got := readFile(t, path); got != "abc\n" {
+		t.Errorf("got current file %q", got)
+	}
+}
+
+func TestRotateInterval(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "api.log")
+	f, err := NewRotatingFile(path, RotateConfig{Interval: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	clock := time.Date(2026, 10, 17, 23, 59, 0, 0, time.UTC)
+	f.now = func() time.Time { return clock }
+	f.opened = clock
+
+	f.Write([]byte("monday\n"))
+	clock = clock.Add(30 * time.Second)
+	f.Write([]byte("still monday\n"))
+	clock = clock.Add(time.Minute)
+	f.Write([]byte("tuesday\n"))
+
+	if got := readFile(t, path); got != "tuesday\n" {
+		t.Errorf("got current file %q", got)
+	}
+	rotated := filepath.Join(filepath.Dir(path), "api-20261018T000030.000.log")
+	if got := readFile(t, rotated); got != "monday\nstill monday\n" {
+		t.Errorf("got rotated file %q", got)
+	}
+}
+
+func TestRotateRetention(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api.log")
+	// files which are not backups of api.log are never deleted
+	for _, name := range []string{"api-errors.log", "other-20200101T000000.000.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := NewRotatingFile(path, RotateConfig{MaxSize: 1, MaxBackups: 2, MaxAge: 90 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	clock := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return clock }
+
+	// every write after the first rotates the file
+	for i := 0; i < 4; i++ {
+		f.Write([]byte("x\n"))
+		clock = clock.Add(time.Minute)
+	}
+	want := []string{
+		"api-20261017T120200.000.log",
+		"api-20261017T120300.000.log",
+		"api-errors.log",
+		"api.log",
+		"other-20200101T000000.000.log",
+	}
+	if got := listDir(t, dir); len(got) != len(want) {
+		t.Fatalf("got files %v; want %v", got, want)
+	} else {
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("got files %v; want %v", got, want)
+				break
+			}
+		}
+	}
+
+	// a rotation 2 hours later leaves only the new backup
+	clock = clock.Add(2 * time.Hour)
+	f.Write([]byte("x\n"))
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || filepath.Base(backups[0].path) != "api-20261017T140400.000.log" {
+		t.Errorf("got backups %v", backups)
+	}
+}
+
+func TestRotateReopen(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "api.log")
+	f, err := NewRotatingFile(path, RotateConfig{MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("12345\n"))
+	f.Close()
+	if _, err := f.Write([]byte("closed\n")); err == nil {
+		t.Error("got nil error writing to a closed file")
+	}
+
+	// the size of an existing file counts towards MaxSize
+	f, err = NewRotatingFile(path, RotateConfig{MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("12345\n"))
+	if got := readFile(t, path); got != "12345\n" {
+		t.Errorf("got current file %q", got)
+	}
+}
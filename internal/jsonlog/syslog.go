@@ -0,0 +1,281 @@
+package jsonlog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslog facilities by name, see RFC 5424 section 6.2.1
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// ParseFacility returns the code of a syslog facility name, e.g. local0
+func ParseFacility(s string) (int, error) {
+	facility, ok := facilities[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", s)
+	}
+	return facility, nil
+}
+
+// syslog severity of an entry of level
+func severity(level Level) int {
+	switch level {
+	case LevelDebug:
+		return 7
+	case LevelInfo:
+		return 6
+	case LevelWarn:
+		return 4
+	case LevelError:
+		return 3
+	default:
+		return 2
+	}
+}
+
+type SyslogConfig struct {
+	// udp, tcp or unix, a unix socket is tried as a datagram socket first
+	Network string
+	// host:port, or the path of a unix socket, e.g. /dev/log
+	Address  string
+	Facility int
+	// APP-NAME of the messages, defaults to the name of the executable
+	AppName string
+}
+
+// ParseSyslogURL parses an address like udp://localhost:514,
+// tcp://logs.example.com:601 or unix:///dev/log
+func ParseSyslogURL(s string) (SyslogConfig, error) {
+	var config SyslogConfig
+	u, err := url.Parse(s)
+	if err != nil {
+		return config, err
+	}
+	config.Network = u.Scheme
+	switch u.Scheme {
+	case "udp", "tcp":
+		config.Address = u.Host
+		if u.Port() == "" {
+			return config, fmt.Errorf("syslog address %q has no port", s)
+		}
+	case "unix":
+		config.Address = u.Path
+		if config.Address == "" {
+			return config, fmt.Errorf("syslog address %q has no socket path", s)
+		}
+	default:
+		return config, fmt.Errorf("syslog address %q must start with udp://, tcp:// or unix://", s)
+	}
+	return config, nil
+}
+
+const (
+	// bounds each dial and write, entries are written while the logger is
+	// locked so an unresponsive server must not block for long
+	syslogTimeout = time.Second
+	// delay before the next dial after a failed one, doubled for every
+	// further failure
+	syslogMinBackoff = time.Second
+	syslogMaxBackoff = time.Minute
+)
+
+// SyslogWriter sends entries as RFC 5424 messages to a syslog server
+// messages over tcp are framed by octet counting as described in RFC 6587
+type SyslogWriter struct {
+	config   SyslogConfig
+	hostname string
+	pid      string
+	dial     func(network, address string) (net.Conn, error)
+
+	mu      sync.Mutex
+	conn    net.Conn
+	network string
+	closed  bool
+	// writes fail with dialErr without dialing until retryAt
+	dialErr error
+	retryAt time.Time
+	backoff time.Duration
+}
+
+// connects to the syslog server, a stream connection which is lost is
+// reconnected on a later write
+// entries written while the server cannot be reached are dropped
+func NewSyslogWriter(config SyslogConfig) (*SyslogWriter, error) {
+	if config.AppName == "" {
+		config.AppName = "-"
+		if exe, err := os.Executable(); err == nil {
+			config.AppName = filepath.Base(exe)
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	w := &SyslogWriter{
+		config:   config,
+		hostname: hostname,
+		pid:      strconv.Itoa(os.Getpid()),
+		dial: func(network, address string) (net.Conn, error) {
+			return net.DialTimeout(network, address, syslogTimeout)
+		},
+	}
+	err = w.connect()
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// dial the server unless a previous dial failed less than backoff ago
+func (w *SyslogWriter) connect() error {
+	if time.Now().Before(w.retryAt) {
+		return w.dialErr
+	}
+	networks := []string{w.config.Network}
+	if w.config.Network == "unix" {
+		networks = []string{"unixgram", "unix"}
+	}
+	var err error
+	for _, network := range networks {
+		var conn net.Conn
+		conn, err = w.dial(network, w.config.Address)
+		if err == nil {
+			w.conn = conn
+			w.network = network
+			w.dialErr = nil
+			w.backoff = 0
+			return nil
+		}
+	}
+	w.fail(err)
+	return err
+}
+
+// drop the connection and fail writes with err until the backoff has passed
+func (w *SyslogWriter) fail(err error) {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+	w.dialErr = err
+	w.backoff = min(max(2*w.backoff, syslogMinBackoff), syslogMaxBackoff)
+	w.retryAt = time.Now().Add(w.backoff)
+}
+
+// Write sends p as a message of severity error, entries of a Logger are
+// written with WriteLevel instead
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(LevelError, p)
+}
+
+func (w *SyslogWriter) WriteLevel(level Level, p []byte) (int, error) {
+	msg := w.format(level, time.Now(), bytes.TrimSuffix(p, []byte("\n")))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, net.ErrClosed
+	}
+	if w.conn == nil {
+		if err := w.connect(); err != nil {
+			return 0, err
+		}
+	}
+	err := w.send(msg)
+	if err != nil && w.stream() && !isTimeout(err) {
+		// retry once on a new connection if the server closed the old one
+		w.conn.Close()
+		w.conn = nil
+		if err = w.connect(); err != nil {
+			return 0, err
+		}
+		err = w.send(msg)
+	}
+	if isTimeout(err) {
+		// the server does not keep up, a stream may also be left with a
+		// partial message
+		w.fail(err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func (w *SyslogWriter) stream() bool {
+	return w.network == "tcp" || w.network == "unix"
+}
+
+// send msg in a datagram, or prefixed by its length on a stream connection
+func (w *SyslogWriter) send(msg []byte) error {
+	if w.stream() {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	err := w.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if err != nil {
+		return err
+	}
+	_, err = w.conn.Write(msg)
+	return err
+}
+
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (w *SyslogWriter) format(level Level, t time.Time, p []byte) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s - - ",
+		w.config.Facility*8+severity(level),
+		t.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname,
+		w.config.AppName,
+		w.pid,
+	)
+	b.Write(p)
+	return b.Bytes()
+}
+
+func (w *SyslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
@@ -0,0 +1,293 @@
+package jsonlog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var rfc5424 = regexp.MustCompile(`^<(\d+)>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z (\S+) greenlight (\d+) - - (.*)$`)
+
+func checkMessage(t *testing.T, msg string, pri int, text string) {
+	t.Helper()
+	m := rfc5424.FindStringSubmatch(msg)
+	if m == nil {
+		t.Fatalf("got message %q; want RFC 5424 format", msg)
+	}
+	if m[1] != strconv.Itoa(pri) {
+		t.Errorf("got PRI %s; want %d", m[1], pri)
+	}
+	if m[3] != strconv.Itoa(os.Getpid()) {
+		t.Errorf("got PROCID %s; want %d", m[3], os.Getpid())
+	}
+	if m[4] != text {
+		t.Errorf("got MSG %q; want %q", m[4], text)
+	}
+}
+
+func TestParseSyslogURL(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		url     string
+		network string
+		address string
+		valid   bool
+	}{
+		{"udp://localhost:514", "udp", "localhost:514", true},
+		{"tcp://logs.example.com:601", "tcp", "logs.example.com:601", true},
+		{"unix:///dev/log", "unix", "/dev/log", true},
+		{"udp://localhost", "", "", false},
+		{"unix://", "", "", false},
+		{"http://localhost:514", "", "", false},
+	}
+	for _, tt := range tests {
+		config, err := ParseSyslogURL(tt.url)
+		if tt.valid != (err == nil) {
+			t.Errorf("%s: got error %v", tt.url, err)
+			continue
+		}
+		if tt.valid && (config.Network != tt.network || config.Address != tt.address) {
+			t.Errorf("%s: got %s %s; want %s %s", tt.url, config.Network, config.Address, tt.network, tt.address)
+		}
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	t.Parallel()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	w, err := NewSyslogWriter(SyslogConfig{Network: "udp", Address: pc.LocalAddr().String(), Facility: 16, AppName: "greenlight"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	logger := New(w, LevelDebug)
+	logger.Warn("slow query")
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	// local0 (16) * 8 + warning (4)
+	checkMessage(t, msg, 132, msg[strings.Index(msg, "{"):])
+	if !strings.Contains(msg, `"message":"slow query"`) || strings.HasSuffix(msg, "\n") {
+		t.Errorf("got message %q; want the entry without a trailing newline", msg)
+	}
+}
+
+// read octet counted messages from conn until it is closed
+func readFrames(conn net.Conn, received chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		length, err := r.ReadString(' ')
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+		if err != nil {
+			return
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return
+		}
+		received <- string(msg)
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conns := make(chan net.Conn, 2)
+	received := make(chan string, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go readFrames(conn, received)
+		}
+	}()
+
+	w, err := NewSyslogWriter(SyslogConfig{Network: "tcp", Address: ln.Addr().String(), Facility: 1, AppName: "greenlight"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	w.WriteLevel(LevelError, []byte("first\n"))
+	// user (1) * 8 + error (3)
+	checkMessage(t, receive(t, received), 11, "first")
+
+	// writes to a closed connection can succeed until the peer resets it,
+	// keep writing until one arrives on a new connection
+	(<-conns).Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		w.WriteLevel(LevelInfo, []byte("second\n"))
+		select {
+		case msg := <-received:
+			checkMessage(t, msg, 14, "second")
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	t.Fatal("no message after the connection was closed")
+}
+
+func TestSyslogBackoff(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	w, err := NewSyslogWriter(SyslogConfig{Network: "tcp", Address: ln.Addr().String(), AppName: "greenlight"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// the server goes away, only the first write dials it again
+	dials := 0
+	errRefused := errors.New("connection refused")
+	w.dial = func(network, address string) (net.Conn, error) {
+		dials++
+		return nil, errRefused
+	}
+	w.mu.Lock()
+	w.conn.Close()
+	w.conn = nil
+	w.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		if _, err := w.WriteLevel(LevelInfo, []byte("dropped\n")); !errors.Is(err, errRefused) {
+			t.Errorf("write %d: got error %v; want %v", i+1, err, errRefused)
+		}
+	}
+	if dials != 1 {
+		t.Errorf("got %d dials; want 1", dials)
+	}
+
+	// the next dial after the backoff fails again and doubles it
+	w.mu.Lock()
+	w.retryAt = time.Now()
+	w.mu.Unlock()
+	w.WriteLevel(LevelInfo, []byte("dropped\n"))
+	if dials != 2 || w.backoff != 2*syslogMinBackoff {
+		t.Errorf("got %d dials and backoff %s; want 2 and %s", dials, w.backoff, 2*syslogMinBackoff)
+	}
+}
+
+func TestSyslogWriteTimeout(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// accept connections but never read from them
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	w, err := NewSyslogWriter(SyslogConfig{Network: "tcp", Address: ln.Addr().String(), AppName: "greenlight"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// fill the socket buffers until a write times out
+	entry := append(bytes.Repeat([]byte("x"), 64*1024), '\n')
+	start := time.Now()
+	for time.Since(start) < 30*time.Second {
+		if _, err = w.WriteLevel(LevelInfo, entry); err != nil {
+			break
+		}
+	}
+	if !isTimeout(err) {
+		t.Fatalf("got error %v; want a timeout", err)
+	}
+	// writes are dropped without blocking until the backoff has passed
+	start = time.Now()
+	if _, err := w.WriteLevel(LevelInfo, entry); err == nil || time.Since(start) > syslogTimeout/2 {
+		t.Errorf("got error %v after %s; want an error without waiting", err, time.Since(start))
+	}
+}
+
+func TestSyslogUnix(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "log.sock")
+	pc, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Skip("unix datagram sockets are not supported:", err)
+	}
+	defer pc.Close()
+
+	config, err := ParseSyslogURL("unix://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.AppName = "greenlight"
+	w, err := NewSyslogWriter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.WriteLevel(LevelDebug, []byte("debug\n"))
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, string(buf[:n]), 7, "debug")
+}
+
+func receive(t *testing.T, ch chan string) string {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return ""
+	}
+}
@@ -4,9 +4,6 @@ import (
 	"bytes"
 	"embed"
 	"html/template"
-	"time"
-
-	"gopkg.in/mail.v2"
 )
 
 // declare variable with type embed.FS to hold email templates
@@ -16,17 +13,35 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
+// Message is a rendered email ready to be delivered by a Transport
+type Message struct {
+	To        string
+	From      string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// Transport delivers rendered messages
+type Transport interface {
+	Send(msg *Message) error
+}
+
 type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+	transport Transport
+	sender    string
 }
 
+// returns a mailer which sends email through an SMTP server
 func New(host string, port int, username, password, sender string) Mailer {
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
+	return NewWithTransport(NewSMTPTransport(host, port, username, password), sender)
+}
+
+// returns a mailer which delivers email with transport
+func NewWithTransport(transport Transport, sender string) Mailer {
 	return Mailer{
-		dialer: dialer,
-		sender: sender,
+		transport: transport,
+		sender:    sender,
 	}
 }
 
@@ -51,17 +66,12 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 		return err
 	}
 
-	// initialize new Message
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-
-	err = m.dialer.DialAndSend(msg)
-	if err != nil {
-		return err
+	msg := &Message{
+		To:        recipient,
+		From:      m.sender,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
 	}
-	return nil
+	return m.transport.Send(msg)
 }
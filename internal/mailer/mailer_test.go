@@ -0,0 +1,91 @@
+package mailer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+)
+
+// transport which keeps messages in memory
+type captureTransport struct {
+	sent []*Message
+}
+
+func (t *captureTransport) Send(msg *Message) error {
+	t.sent = append(t.sent, msg)
+	return nil
+}
+
+func TestSendRendersTemplate(t *testing.T) {
+	transport := &captureTransport{}
+	m := NewWithTransport(transport, "GreenLight <no-reply@greenlight.com>")
+
+	err := m.Send("alice@example.com", "user_welcome.tmpl.html", map[string]any{
+		"activationToken": "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+		"userID":          7,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transport.sent) != 1 {
+		t.Fatalf("got %d messages; want 1", len(transport.sent))
+	}
+	msg := transport.sent[0]
+	if msg.To != "alice@example.com" || msg.Subject != "Welcome to GreenLight" {
+		t.Errorf("got to %q subject %q", msg.To, msg.Subject)
+	}
+	for _, body := range []string{msg.PlainBody, msg.HTMLBody} {
+		if !strings.Contains(body, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") {
+			t.Errorf("body does not contain activation token: %q", body)
+		}
+	}
+}
+
+func TestSendMissingTemplate(t *testing.T) {
+	m := NewWithTransport(&captureTransport{}, "no-reply@greenlight.com")
+	if err := m.Send("alice@example.com", "missing.tmpl.html", nil); err == nil {
+		t.Error("expected error for missing template")
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	transport, err := NewFileTransport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transport.Send(&Message{To: "alice@example.com", From: "no-reply@greenlight.com", Subject: "Hello", PlainBody: "plain", HTMLBody: "<p>html</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("got %d files; want 1", len(files))
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(content, []byte("Subject: Hello")) || !bytes.Contains(content, []byte("To: alice@example.com")) {
+		t.Errorf("unexpected file content %q", content)
+	}
+}
+
+func TestLogTransport(t *testing.T) {
+	var buf bytes.Buffer
+	transport := NewLogTransport(jsonlog.New(&buf, jsonlog.LevelInfo))
+	err := transport.Send(&Message{To: "alice@example.com", Subject: "Hello", PlainBody: "plain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"subject":"Hello"`) {
+		t.Errorf("unexpected log output %q", buf.String())
+	}
+}
@@ -0,0 +1,354 @@
+// Package metrics implements counters, gauges and histograms which are
+// exported in the Prometheus text exposition format.
+//
+// Only the parts of the format used by the application are supported: metric
+// families with a fixed set of labels, HELP and TYPE lines, and cumulative
+// histogram buckets.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are histogram buckets in seconds suited to HTTP request latencies
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metric families in the order they were registered
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+	names    map[string]bool
+}
+
+type family struct {
+	name  string
+	help  string
+	typ   string
+	write func(w *bufio.Writer, name string)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name, help, typ string, write func(w *bufio.Writer, name string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric name " + name)
+	}
+	r.names[name] = true
+	r.families = append(r.families, &family{name: name, help: help, typ: typ, write: write})
+}
+
+// NewCounter registers a counter without labels
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{}
+	r.register(name, help, "counter", func(w *bufio.Writer, name string) {
+		writeSample(w, name, nil, nil, c.Value())
+	})
+	return c
+}
+
+// NewCounterFunc registers a counter whose value is read from fn on every scrape
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(name, help, "counter", func(w *bufio.Writer, name string) {
+		writeSample(w, name, nil, nil, fn())
+	})
+}
+
+// NewCounterVec registers a counter with one child per combination of label values
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{vec: newVec(labels, func() *Counter { return &Counter{} })}
+	r.register(name, help, "counter", func(w *bufio.Writer, name string) {
+		for _, child := range v.sorted() {
+			writeSample(w, name, labels, child.values, child.metric.Value())
+		}
+	})
+	return v
+}
+
+// NewGauge registers a gauge without labels
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.register(name, help, "gauge", func(w *bufio.Writer, name string) {
+		writeSample(w, name, nil, nil, g.Value())
+	})
+	return g
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn on every scrape
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, help, "gauge", func(w *bufio.Writer, name string) {
+		writeSample(w, name, nil, nil, fn())
+	})
+}
+
+// NewHistogramVec registers a histogram with one child per combination of
+// label values, buckets are upper bounds in increasing order
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	v := &HistogramVec{vec: newVec(labels, func() *Histogram { return newHistogram(buckets) })}
+	r.register(name, help, "histogram", func(w *bufio.Writer, name string) {
+		for _, child := range v.sorted() {
+			child.metric.write(w, name, labels, child.values)
+		}
+	})
+	return v
+}
+
+// WriteTo writes every metric family in the text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+		f.write(bw, f.name)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler returns a handler which serves the metrics of the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// Counter is a value which only goes up
+type Counter struct {
+	bits atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter by v, which must not be negative
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter can not decrease")
+	}
+	addFloat(&c.bits, v)
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// Gauge is a value which can go up and down
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Add(v float64) {
+	addFloat(&g.bits, v)
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	mu          sync.Mutex
+	upperBounds []float64
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		upperBounds: buckets,
+		counts:      make([]uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// counts are stored per bucket and summed up when written
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer, name string, labels, values []string) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	count, sum := h.count, h.sum
+	h.mu.Unlock()
+
+	bucketLabels := append(append([]string(nil), labels...), "le")
+	var cumulative uint64
+	for i, upperBound := range h.upperBounds {
+		cumulative += counts[i]
+		bucketValues := append(append([]string(nil), values...), formatFloat(upperBound))
+		writeSample(w, name+"_bucket", bucketLabels, bucketValues, float64(cumulative))
+	}
+	bucketValues := append(append([]string(nil), values...), "+Inf")
+	writeSample(w, name+"_bucket", bucketLabels, bucketValues, float64(count))
+	writeSample(w, name+"_sum", labels, values, sum)
+	writeSample(w, name+"_count", labels, values, float64(count))
+}
+
+type CounterVec struct {
+	*vec[Counter]
+}
+
+// WithLabelValues returns the counter for values, given in the order of the
+// label names passed to NewCounterVec
+func (v *CounterVec) WithLabelValues(values ...string) *Counter {
+	return v.with(values)
+}
+
+type HistogramVec struct {
+	*vec[Histogram]
+}
+
+// WithLabelValues returns the histogram for values, given in the order of the
+// label names passed to NewHistogramVec
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return v.with(values)
+}
+
+type vec[T any] struct {
+	labels   []string
+	newChild func() *T
+	mu       sync.Mutex
+	children map[string]*vecChild[T]
+}
+
+type vecChild[T any] struct {
+	values []string
+	metric *T
+}
+
+func newVec[T any](labels []string, newChild func() *T) *vec[T] {
+	return &vec[T]{
+		labels:   labels,
+		newChild: newChild,
+		children: make(map[string]*vecChild[T]),
+	}
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: got %d label values for %d labels", len(values), len(v.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	child, found := v.children[key]
+	if !found {
+		child = &vecChild[T]{values: append([]string(nil), values...), metric: v.newChild()}
+		v.children[key] = child
+	}
+	return child.metric
+}
+
+// returns the children sorted by label values so the output is stable
+func (v *vec[T]) sorted() []*vecChild[T] {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	children := make([]*vecChild[T], len(keys))
+	for i, key := range keys {
+		children[i] = v.children[key]
+	}
+	v.mu.Unlock()
+	return children
+}
+
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(label)
+			w.WriteString(`="`)
+			w.WriteString(labelValueEscaper.Replace(values[i]))
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	t.Parallel()
+	r := NewRegistry()
+	requests := r.NewCounterVec("http_requests_total", "Requests by route.", "method", "route")
+	inFlight := r.NewGauge("http_requests_in_flight", "Requests being served.")
+	r.NewGaugeFunc("connections", "Open connections.", func() float64 { return 3 })
+	latency := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+
+	requests.WithLabelValues("GET", "/v1/movies/:id").Inc()
+	requests.WithLabelValues("GET", "/v1/movies/:id").Add(2)
+	requests.WithLabelValues("POST", `/say/"hi"`).Inc()
+	inFlight.Inc()
+	inFlight.Inc()
+	inFlight.Dec()
+	for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+		latency.WithLabelValues("/v1/movies").Observe(v)
+	}
+
+	want := `# HELP http_requests_total Requests by route.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",route="/v1/movies/:id"} 3
+http_requests_total{method="POST",route="/say/\"hi\""} 1
+# HELP http_requests_in_flight Requests being served.
+# TYPE http_requests_in_flight gauge
+http_requests_in_flight 1
+# HELP connections Open connections.
+# TYPE connections gauge
+connections 3
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/v1/movies",le="0.1"} 2
+latency_seconds_bucket{route="/v1/movies",le="1"} 3
+latency_seconds_bucket{route="/v1/movies",le="+Inf"} 4
+latency_seconds_sum{route="/v1/movies"} 2.65
+latency_seconds_count{route="/v1/movies"} 4
+`
+	var b strings.Builder
+	n, err := r.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if n != int64(len(want)) {
+		t.Errorf("got %d bytes written; want %d", n, len(want))
+	}
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+	r := NewRegistry()
+	r.NewCounter("jobs_total", "Jobs.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("got Content-Type %q", got)
+	}
+	if !strings.Contains(rec.Body.String(), "jobs_total 1\n") {
+		t.Errorf("missing sample in %q", rec.Body.String())
+	}
+}
+
+func TestPanics(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		fn   func(r *Registry)
+	}{
+		{"Duplicate name", func(r *Registry) { r.NewCounter("a", ""); r.NewGauge("a", "") }},
+		{"Negative counter", func(r *Registry) { r.NewCounter("a", "").Add(-1) }},
+		{"Label count", func(r *Registry) { r.NewCounterVec("a", "", "x", "y").WithLabelValues("1") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected panic")
+				}
+			}()
+			tt.fn(NewRegistry())
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testSpan(t *testing.T) SpanData {
+	t.Helper()
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0)
+	return SpanData{
+		Name:         "GET /v1/movies/:id",
+		SpanContext:  SpanContext{TraceID: sc.TraceID, SpanID: SpanID{1, 2, 3, 4, 5, 6, 7, 8}, Sampled: true},
+		ParentSpanID: sc.SpanID,
+		Kind:         KindServer,
+		StartTime:    start,
+		EndTime:      start.Add(1500 * time.Microsecond),
+		Attributes:   []Attribute{String("http.route", "/v1/movies/:id"), Int("http.response.status_code", 500)},
+		Error:        "Internal Server Error",
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	err := NewStdoutExporter(&buf).Export(context.Background(), []SpanData{testSpan(t), testSpan(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines; want 2", len(lines))
+	}
+	var got map[string]any
+	if err := json.Unmarshal(lines[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"name":           "GET /v1/movies/:id",
+		"trace_id":       "4bf92f3577b34da6a3ce929d0e0e4736",
+		"span_id":        "0102030405060708",
+		"parent_span_id": "00f067aa0ba902b7",
+		"kind":           "server",
+		"duration_ms":    1.5,
+		"error":          "Internal Server Error",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("got %s %v; want %v", key, got[key], value)
+		}
+	}
+	if attributes, _ := got["attributes"].(map[string]any); attributes["http.response.status_code"] != float64(500) {
+		t.Errorf("got attributes %v", got["attributes"])
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	t.Parallel()
+	var (
+		gotPath        string
+		gotContentType string
+		gotBody        otlpRequest
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotContentType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&gotBody); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, "{}")
+	}))
+	t.Cleanup(collector.Close)
+
+	err := NewOTLPExporter(collector.URL+"/", "greenlight").Export(context.Background(), []SpanData{testSpan(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/v1/traces" {
+		t.Errorf("got path %q; want %q", gotPath, "/v1/traces")
+	}
+	if gotContentType != "application/json" {
+		t.Errorf("got Content-Type %q; want %q", gotContentType, "application/json")
+	}
+
+	if len(gotBody.ResourceSpans) != 1 || len(gotBody.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("got body %+v", gotBody)
+	}
+	resource := gotBody.ResourceSpans[0].Resource.Attributes
+	if len(resource) != 1 || resource[0].Key != "service.name" || *resource[0].Value.StringValue != "greenlight" {
+		t.Errorf("got resource attributes %+v", resource)
+	}
+	spans := gotBody.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans; want 1", len(spans))
+	}
+	span := spans[0]
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"traceId", span.TraceID, "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"spanId", span.SpanID, "0102030405060708"},
+		{"parentSpanId", span.ParentSpanID, "00f067aa0ba902b7"},
+		{"kind", span.Kind, KindServer},
+		{"startTimeUnixNano", span.StartTimeUnixNano, "1700000000000000000"},
+		{"endTimeUnixNano", span.EndTimeUnixNano, "1700000000001500000"},
+		{"status", span.Status, otlpStatus{Code: otlpStatusError, Message: "Internal Server Error"}},
+		{"int attribute", *span.Attributes[1].Value.IntValue, "500"},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("got %s %v; want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestOTLPExporterError(t *testing.T) {
+	t.Parallel()
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(collector.Close)
+
+	err := NewOTLPExporter(collector.URL, "greenlight").Export(context.Background(), []SpanData{testSpan(t)})
+	if err == nil {
+		t.Fatal("got nil error for status 503")
+	}
+
+	collector.Close()
+	err = NewOTLPExporter(collector.URL, "greenlight").Export(context.Background(), []SpanData{testSpan(t)})
+	var netErr interface{ Timeout() bool }
+	if err == nil || !errors.As(err, &netErr) {
+		t.Errorf("got error %v; want a connection error", err)
+	}
+}
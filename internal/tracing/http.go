@@ -0,0 +1,33 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+)
+
+type middlewareSpanKey struct{}
+
+// Middleware wraps mw so that a span named name records the time mw spends
+// before it calls the next handler, or until it returns if it responds
+// without calling the next handler. Spans started by the next handler are
+// children of the span which was current when mw was called.
+func Middleware(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if span, _ := ctx.Value(middlewareSpanKey{}).(*Span); span != nil {
+				span.End()
+				ctx = ContextWithSpan(ctx, span.parent)
+				ctx = context.WithValue(ctx, middlewareSpanKey{}, (*Span)(nil))
+				r = r.WithContext(ctx)
+			}
+			next.ServeHTTP(w, r)
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, span := Start(r.Context(), name)
+			defer span.End()
+			ctx = context.WithValue(ctx, middlewareSpanKey{}, span)
+			handler.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
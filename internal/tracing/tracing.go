@@ -0,0 +1,443 @@
+// Package tracing records spans of work within a request and exports them in
+// batches.
+//
+// Trace context is propagated with W3C traceparent headers. A span is started
+// with Tracer.Start for the root of a trace in this process, or with Start for
+// a child of the span in a context. Functions and methods are safe to call on
+// a nil *Span, which is returned when tracing is disabled, so instrumented
+// code does not need to check whether a trace is being recorded.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+)
+
+// TraceID identifies all spans of a trace
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID identifies a single span within a trace
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext is the part of a span which is propagated to other processes
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent returns sc formatted as a version 00 traceparent header
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses a traceparent header as described in
+// https://www.w3.org/TR/trace-context/#traceparent-header
+// fields added by versions after 00 are ignored
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	// version-traceid-spanid-flags
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+	version, err := decodeHex(s[0:2], 1)
+	if err != nil || version[0] == 0xff {
+		return sc, ErrInvalidTraceparent
+	}
+	if len(s) > 55 && (version[0] == 0 || s[55] != '-') {
+		return sc, ErrInvalidTraceparent
+	}
+	traceID, err := decodeHex(s[3:35], 16)
+	if err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	spanID, err := decodeHex(s[36:52], 8)
+	if err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	flags, err := decodeHex(s[53:55], 1)
+	if err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&0x01 == 0x01
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	return sc, nil
+}
+
+// decode lowercase hex of exactly n bytes
+func decodeHex(s string, n int) ([]byte, error) {
+	for _, c := range s {
+		if ('0' > c || c > '9') && ('a' > c || c > 'f') {
+			return nil, ErrInvalidTraceparent
+		}
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != n {
+		return nil, ErrInvalidTraceparent
+	}
+	return b, nil
+}
+
+type SpanKind int
+
+// span kinds as numbered by OpenTelemetry
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+// Attribute is a key and a value of type string, int64, float64 or bool
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanData is a snapshot of an ended span passed to an Exporter
+type SpanData struct {
+	Name         string
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	Kind         SpanKind
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   []Attribute
+	// Error is the message of the error recorded with RecordError, if any
+	Error string
+}
+
+// Span records the timing and attributes of a single operation
+type Span struct {
+	tracer *Tracer
+	// span which was current when this span was started, if it is local
+	parent *Span
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext returns the identifiers of s, or an invalid SpanContext if s is nil
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetName replaces the name the span was started with
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Name = name
+}
+
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attributes...)
+}
+
+// RecordError marks the span as failed with the message of err, a nil err is ignored
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End records the end time and queues a sampled span for export
+// calls after the first have no effect
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.SpanContext.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
+
+type spanContextKey struct{}
+
+type remoteParentKey struct{}
+
+// ContextWithSpan returns ctx with span as the current span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the current span of ctx, or nil if there is none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteParent returns ctx with sc as the parent of the next span
+// started with Tracer.Start, e.g. from a traceparent header
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteParentKey{}, sc)
+}
+
+type StartOption func(*SpanData)
+
+func WithKind(kind SpanKind) StartOption {
+	return func(d *SpanData) { d.Kind = kind }
+}
+
+func WithAttributes(attributes ...Attribute) StartOption {
+	return func(d *SpanData) { d.Attributes = append(d.Attributes, attributes...) }
+}
+
+// Start starts a child of the current span of ctx with the same tracer
+// it returns ctx and a nil span if ctx has no current span
+func Start(ctx context.Context, name string, opts ...StartOption) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, opts...)
+}
+
+type Config struct {
+	// fraction of new traces which are recorded, traces continued from a
+	// traceparent header follow the sampled flag of the header instead
+	SampleRatio float64
+	// maximum number of spans passed to the exporter at once
+	BatchSize int
+	// time after which spans are exported even if the batch is not full
+	FlushInterval time.Duration
+	// spans ended while QueueSize spans are waiting for export are dropped
+	QueueSize int
+}
+
+// Tracer starts spans and exports them once they end
+type Tracer struct {
+	exporter Exporter
+	logger   *jsonlog.Logger
+	config   Config
+	queue    chan SpanData
+	dropped  atomic.Int64
+}
+
+// returns a tracer which passes ended spans to exporter, if exporter is nil
+// no spans are recorded
+func New(exporter Exporter, logger *jsonlog.Logger, config Config) *Tracer {
+	if config.BatchSize < 1 {
+		config.BatchSize = 512
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.QueueSize < config.BatchSize {
+		config.QueueSize = 4 * config.BatchSize
+	}
+	return &Tracer{
+		exporter: exporter,
+		logger:   logger,
+		config:   config,
+		queue:    make(chan SpanData, config.QueueSize),
+	}
+}
+
+// Enabled reports whether spans are recorded
+func (t *Tracer) Enabled() bool {
+	return t.exporter != nil
+}
+
+// Dropped returns the number of spans dropped because the queue was full
+func (t *Tracer) Dropped() int64 {
+	return t.dropped.Load()
+}
+
+// Start starts a span which is a child of the current span of ctx, or of the
+// remote parent of ctx, or the root of a new trace, and returns ctx with the
+// span as its current span
+func (t *Tracer) Start(ctx context.Context, name string, opts ...StartOption) (context.Context, *Span) {
+	if !t.Enabled() {
+		return ctx, nil
+	}
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:      name,
+			Kind:      KindInternal,
+			StartTime: time.Now(),
+		},
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.parent = parent
+		span.data.SpanContext = parent.data.SpanContext
+		span.data.ParentSpanID = parent.data.SpanContext.SpanID
+	} else if remote, ok := ctx.Value(remoteParentKey{}).(SpanContext); ok && remote.IsValid() {
+		span.data.SpanContext = remote
+		span.data.ParentSpanID = remote.SpanID
+	} else {
+		rand.Read(span.data.SpanContext.TraceID[:])
+		span.data.SpanContext.Sampled = t.sample(span.data.SpanContext.TraceID)
+	}
+	rand.Read(span.data.SpanContext.SpanID[:])
+	for _, opt := range opts {
+		opt(&span.data)
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// sample the same fraction of traces on every instance by deciding on the
+// random part of the trace id
+func (t *Tracer) sample(id TraceID) bool {
+	switch {
+	case t.config.SampleRatio >= 1:
+		return true
+	case t.config.SampleRatio <= 0:
+		return false
+	}
+	return binary.BigEndian.Uint64(id[8:]) < uint64(t.config.SampleRatio*math.MaxUint64)
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case t.queue <- data:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Run exports ended spans in batches until ctx is canceled, spans which are
+// queued at that point are exported before Run returns
+func (t *Tracer) Run(ctx context.Context) {
+	if !t.Enabled() {
+		return
+	}
+	ticker := time.NewTicker(t.config.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, t.config.BatchSize)
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) < t.config.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			t.flush(batch)
+			return
+		}
+		t.export(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+// export the remaining spans of the queue after Run was stopped
+func (t *Tracer) flush(batch []SpanData) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) < t.config.BatchSize {
+				continue
+			}
+		default:
+			t.export(ctx, batch)
+			return
+		}
+		t.export(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+func (t *Tracer) export(ctx context.Context, batch []SpanData) {
+	if len(batch) == 0 {
+		return
+	}
+	err := t.exporter.Export(ctx, batch)
+	if err != nil {
+		t.logger.Error(err, jsonlog.Int("spans", len(batch)))
+	}
+}
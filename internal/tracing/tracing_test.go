@@ -0,0 +1,260 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/anukuljoshi/greenlight/internal/jsonlog"
+)
+
+// exporter which keeps every exported span
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) Export(ctx context.Context, spans []SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+// returns a tracer which records spans and a function which exports the
+// queued spans and returns all recorded spans
+func newTestTracer(t *testing.T, config Config) (*Tracer, func() []SpanData) {
+	t.Helper()
+	rec := &recorder{}
+	tracer := New(rec, jsonlog.New(io.Discard, jsonlog.LevelOff), config)
+	flush := func() []SpanData {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		tracer.Run(ctx)
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return append([]SpanData(nil), rec.spans...)
+	}
+	return tracer, flush
+}
+
+// returns spans by name, the last span wins if names repeat
+func byName(spans []SpanData) map[string]SpanData {
+	named := make(map[string]SpanData)
+	for _, span := range spans {
+		named[span.Name] = span
+	}
+	return named
+}
+
+func TestParseTraceparent(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{"Sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"Not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false},
+		{"Future version", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"Empty", "", "", true},
+		{"Invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", true},
+		{"Version 00 with extra fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "", true},
+		{"Uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "", true},
+		{"Zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", true},
+		{"Zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", true},
+		{"Bad separator", "00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", true},
+	}
+	for _, tt := range tests {
+		sc, err := ParseTraceparent(tt.header)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidTraceparent) {
+				t.Errorf("%s: got error %v; want %v", tt.name, err, ErrInvalidTraceparent)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: got error %v", tt.name, err)
+			continue
+		}
+		if got := sc.Traceparent(); got != tt.want {
+			t.Errorf("%s: got %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	t.Parallel()
+	tracer, flush := newTestTracer(t, Config{SampleRatio: 1})
+
+	ctx, root := tracer.Start(context.Background(), "root", WithKind(KindServer))
+	childCtx, child := Start(ctx, "child", WithAttributes(String("key", "value")))
+	child.RecordError(errors.New("boom"))
+	child.End()
+	_, grandchild := Start(childCtx, "grandchild")
+	grandchild.End()
+	root.End()
+	root.End()
+
+	spans := byName(flush())
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans; want 3", len(spans))
+	}
+	traceID := spans["root"].SpanContext.TraceID
+	tests := []struct {
+		name   string
+		parent SpanID
+	}{
+		{"root", SpanID{}},
+		{"child", spans["root"].SpanContext.SpanID},
+		{"grandchild", spans["child"].SpanContext.SpanID},
+	}
+	for _, tt := range tests {
+		span := spans[tt.name]
+		if span.SpanContext.TraceID != traceID {
+			t.Errorf("%s: got trace id %s; want %s", tt.name, span.SpanContext.TraceID, traceID)
+		}
+		if span.ParentSpanID != tt.parent {
+			t.Errorf("%s: got parent %s; want %s", tt.name, span.ParentSpanID, tt.parent)
+		}
+	}
+	if got := spans["root"].Kind; got != KindServer {
+		t.Errorf("got kind %s; want %s", got, KindServer)
+	}
+	if got := spans["child"].Error; got != "boom" {
+		t.Errorf("got error %q; want %q", got, "boom")
+	}
+	if got := spans["child"].Attributes; len(got) != 1 || got[0] != String("key", "value") {
+		t.Errorf("got attributes %v", got)
+	}
+}
+
+func TestStartRemoteParent(t *testing.T) {
+	t.Parallel()
+	tracer, flush := newTestTracer(t, Config{SampleRatio: 0})
+
+	tests := []struct {
+		name        string
+		traceparent string
+		wantSpan    bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false},
+		{"new trace", "", false},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.traceparent != "" {
+			sc, err := ParseTraceparent(tt.traceparent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx = ContextWithRemoteParent(ctx, sc)
+		}
+		_, span := tracer.Start(ctx, tt.name)
+		span.End()
+	}
+
+	spans := byName(flush())
+	for _, tt := range tests {
+		span, found := spans[tt.name]
+		if found != tt.wantSpan {
+			t.Errorf("%s: got exported %t; want %t", tt.name, found, tt.wantSpan)
+		}
+		if !found {
+			continue
+		}
+		if got := span.SpanContext.TraceID.String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("%s: got trace id %s", tt.name, got)
+		}
+		if got := span.ParentSpanID.String(); got != "00f067aa0ba902b7" {
+			t.Errorf("%s: got parent %s", tt.name, got)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	t.Parallel()
+	tracer := New(nil, jsonlog.New(io.Discard, jsonlog.LevelOff), Config{})
+	ctx, span := tracer.Start(context.Background(), "root")
+	if span != nil {
+		t.Fatalf("got span %v; want nil", span)
+	}
+	// calls on a nil span must not panic
+	_, child := Start(ctx, "child")
+	child.SetName("renamed")
+	child.SetAttributes(Bool("ok", true))
+	child.RecordError(errors.New("boom"))
+	child.End()
+	if sc := child.SpanContext(); sc.IsValid() {
+		t.Errorf("got valid span context %v", sc)
+	}
+	tracer.Run(ctx)
+}
+
+func TestQueueFull(t *testing.T) {
+	t.Parallel()
+	tracer, flush := newTestTracer(t, Config{SampleRatio: 1, BatchSize: 1, QueueSize: 2})
+	for i := 0; i < 5; i++ {
+		_, span := tracer.Start(context.Background(), "span")
+		span.End()
+	}
+	flush()
+	if got := tracer.Dropped(); got != 3 {
+		t.Errorf("got %d dropped spans; want 3", got)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+	tracer, flush := newTestTracer(t, Config{SampleRatio: 1})
+
+	reject := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/rejected" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	var handlerParent SpanID
+	handler := Middleware("reject", reject)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "handler")
+		defer span.End()
+		handlerParent = span.data.ParentSpanID
+	}))
+
+	for _, path := range []string{"/accepted", "/rejected"} {
+		ctx, root := tracer.Start(context.Background(), "request "+path)
+		req := httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		root.End()
+	}
+
+	spans := flush()
+	named := byName(spans)
+	// the handler span is a sibling of the middleware span, not its child
+	accepted := named["request /accepted"].SpanContext.SpanID
+	if handlerParent != accepted {
+		t.Errorf("got handler parent %s; want request span %s", handlerParent, accepted)
+	}
+	var middlewareSpans int
+	for _, span := range spans {
+		if span.Name != "reject" {
+			continue
+		}
+		middlewareSpans++
+		if span.ParentSpanID == accepted && span.EndTime.After(named["handler"].StartTime) {
+			t.Errorf("middleware span ended after the handler started")
+		}
+	}
+	if middlewareSpans != 2 {
+		t.Errorf("got %d middleware spans; want 2", middlewareSpans)
+	}
+}
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS email_changes (
+    token_hash bytea PRIMARY KEY REFERENCES tokens ON DELETE CASCADE,
+    new_email citext NOT NULL
+);
@@ -0,0 +1,9 @@
+INSERT INTO permissions (code)
+VALUES
+    ('debug:read');
+
+-- the admin role bundles every permission
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'debug:read';